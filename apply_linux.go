@@ -0,0 +1,155 @@
+//go:build linux
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/tomasen/chnroutes/route"
+	"github.com/vishvananda/netlink"
+)
+
+// routeProtocol tags the routes apply installs, an id no routing daemon
+// uses, so that apply never touches routes it did not add itself.
+const routeProtocol = netlink.RouteProtocol(200)
+
+func apply(args []string) error {
+	var sel selection
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	table := fs.Int("table", 0, "Routing table the routes are installed in, 0 for main. main by default.")
+	gw := fs.String("gw", "", "Address or interface name of the ipv4 gateway, the gateway of the current default route by default")
+	gw6 := fs.String("gw6", "", "Address or interface name of the ipv6 gateway, the gateway of the current default route by default")
+	metric := fs.Int("m", 5, "Metric setting for the route rules")
+	remove := fs.Bool("remove", false, "Remove the routes apply installed in the table instead of installing them")
+	sel.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var routes []netip.Prefix
+	if !*remove {
+		q, err := sel.query()
+		if err != nil {
+			return err
+		}
+		if _, routes, err = sel.routes(q); err != nil {
+			return err
+		}
+	}
+	t := *table
+	if t == 0 {
+		t = syscall.RT_TABLE_MAIN
+	}
+	added, removed, err := applyRoutes(routes, t, *metric, *gw, *gw6)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Added %d and removed %d routes in table %d.\n", len(added), len(removed), t)
+	return nil
+}
+
+// applyRoutes makes routes the only routes of routeProtocol in table. It
+// leaves the routes that are already right alone, deletes the others and
+// adds the missing ones, and returns the prefixes it added and deleted.
+func applyRoutes(routes []netip.Prefix, table, metric int, gw, gw6 string) (added, removed []netip.Prefix, err error) {
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		var want []netip.Prefix
+		for _, p := range routes {
+			if p.Addr().Is4() == (family == netlink.FAMILY_V4) {
+				want = append(want, p)
+			}
+		}
+		installed, err := netlink.RouteListFiltered(family, &netlink.Route{Table: table, Protocol: routeProtocol}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_PROTOCOL)
+		if err != nil {
+			return added, removed, err
+		}
+		if len(want) == 0 && len(installed) == 0 {
+			continue
+		}
+
+		var hop netlink.Route
+		if len(want) > 0 {
+			spec := gw
+			if family == netlink.FAMILY_V6 {
+				spec = gw6
+			}
+			if hop, err = nextHop(family, spec); err != nil {
+				return added, removed, err
+			}
+		}
+		wanted := make(map[netip.Prefix]bool, len(want))
+		for _, p := range want {
+			wanted[p] = true
+		}
+		var kept []netip.Prefix
+		for _, r := range installed {
+			p := prefixOf(r.Dst)
+			if wanted[p] && r.Priority == metric && (hop.Gw == nil || hop.Gw.Equal(r.Gw)) && (hop.LinkIndex == 0 || hop.LinkIndex == r.LinkIndex) {
+				kept = append(kept, p)
+				continue
+			}
+			r := r
+			if err := netlink.RouteDel(&r); err != nil {
+				return added, removed, fmt.Errorf("delete route %s: %v", p, err)
+			}
+			removed = append(removed, p)
+		}
+
+		add, _ := route.Diff(kept, want)
+		for _, p := range add {
+			r := hop
+			r.Dst = &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())}
+			r.Table = table
+			r.Priority = metric
+			r.Protocol = routeProtocol
+			if err := netlink.RouteAdd(&r); err != nil {
+				return added, removed, fmt.Errorf("add route %s: %v", p, err)
+			}
+			added = append(added, p)
+		}
+	}
+	return added, removed, nil
+}
+
+// nextHop returns a route holding the gateway given as an address or an
+// interface name, or the gateway of the current default route of the
+// family when spec is empty.
+func nextHop(family int, spec string) (netlink.Route, error) {
+	if spec == "" {
+		defaults, err := netlink.RouteListFiltered(family, &netlink.Route{Table: syscall.RT_TABLE_MAIN}, netlink.RT_FILTER_TABLE)
+		if err != nil {
+			return netlink.Route{}, err
+		}
+		var best *netlink.Route
+		for i, r := range defaults {
+			if (r.Dst == nil || prefixOf(r.Dst).Bits() == 0) && (best == nil || r.Priority < best.Priority) {
+				best = &defaults[i]
+			}
+		}
+		if best == nil {
+			return netlink.Route{}, fmt.Errorf("No default route to take the gateway from, please set one with -gw or -gw6.")
+		}
+		return netlink.Route{Gw: best.Gw, LinkIndex: best.LinkIndex}, nil
+	}
+	if addr, err := netip.ParseAddr(spec); err == nil {
+		return netlink.Route{Gw: net.IP(addr.AsSlice())}, nil
+	}
+	link, err := netlink.LinkByName(spec)
+	if err != nil {
+		return netlink.Route{}, fmt.Errorf("Interface %s: %v", spec, err)
+	}
+	return netlink.Route{LinkIndex: link.Attrs().Index, Scope: netlink.SCOPE_LINK}, nil
+}
+
+func prefixOf(n *net.IPNet) netip.Prefix {
+	if n == nil {
+		return netip.Prefix{}
+	}
+	addr, _ := netip.AddrFromSlice(n.IP)
+	bits, _ := n.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), bits).Masked()
+}
@@ -0,0 +1,105 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+// TestApplyRoutes runs itself again inside new user and network namespaces,
+// where it may change routes without any privilege on the host.
+func TestApplyRoutes(t *testing.T) {
+	if os.Getenv("CHNROUTES_NETNS") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestApplyRoutes$")
+		cmd.Env = append(os.Environ(), "CHNROUTES_NETNS=1")
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		}
+		out, err := cmd.CombinedOutput()
+		if _, ok := err.(*exec.ExitError); ok {
+			t.Fatalf("%s", out)
+		} else if err != nil {
+			t.Skipf("cannot create a network namespace: %v", err)
+		}
+		return
+	}
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "v0"}, PeerName: "v1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"v0", "v1"} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link, _ := netlink.LinkByName("v0")
+	addr, _ := netlink.ParseAddr("10.9.0.2/24")
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.RouteAdd(&netlink.Route{Gw: net.ParseIP("10.9.0.1")}); err != nil {
+		t.Fatal(err)
+	}
+	// A route of someone else in the same table must survive.
+	_, other, _ := net.ParseCIDR("198.51.100.0/24")
+	if err := netlink.RouteAdd(&netlink.Route{Dst: other, LinkIndex: link.Attrs().Index, Table: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(ss ...string) []netip.Prefix {
+		var prefixes []netip.Prefix
+		for _, s := range ss {
+			prefixes = append(prefixes, netip.MustParsePrefix(s))
+		}
+		return prefixes
+	}
+	steps := []struct {
+		routes         []netip.Prefix
+		gw6            string
+		added, removed int
+		table4, table6 int
+	}{
+		{parse("1.0.1.0/24", "1.0.2.0/23", "2001:250::/31"), "v0", 3, 0, 3, 1},
+		{parse("1.0.1.0/24", "1.0.2.0/23", "2001:250::/31"), "v0", 0, 0, 3, 1},
+		{parse("1.0.1.0/24", "1.0.8.0/21", "2001:250::/31"), "v0", 1, 1, 3, 1},
+		{nil, "", 0, 3, 1, 0},
+	}
+	for i, s := range steps {
+		// The ipv4 gateway is taken from the default route.
+		added, removed, err := applyRoutes(s.routes, 100, 5, "", s.gw6)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if len(added) != s.added || len(removed) != s.removed {
+			t.Errorf("step %d: added %v and removed %v", i, added, removed)
+		}
+		for family, want := range map[int]int{netlink.FAMILY_V4: s.table4, netlink.FAMILY_V6: s.table6} {
+			got, err := netlink.RouteListFiltered(family, &netlink.Route{Table: 100}, netlink.RT_FILTER_TABLE)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != want {
+				t.Errorf("step %d: table 100 holds %v, want %d routes", i, got, want)
+			}
+			for _, r := range got {
+				if r.Dst.String() == "1.0.1.0/24" && (!r.Gw.Equal(net.ParseIP("10.9.0.1")) || r.Priority != 5) {
+					t.Errorf("step %d: got %v", i, r)
+				}
+			}
+		}
+	}
+}
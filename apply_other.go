@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func apply(args []string) error {
+	return errors.New("Command apply is only supported on linux.")
+}
@@ -2,201 +2,708 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
-	"math"
-	"net"
-	"net/http"
+	"net/netip"
 	"os"
-	"regexp"
-	"strconv"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tomasen/chnroutes/route"
 )
 
-type apnicData struct {
-	startIp string
-	mask    net.IP
-	maskNum int
+var commands = map[string]func(args []string) error{
+	"generate":       generate,
+	"fetch":          fetch,
+	"list-platforms": listPlatforms,
+	"stats":          stats,
+	"lookup":         lookup,
+	"apply":          apply,
+	"diff":           diff,
+	"geoip":          geoip,
 }
 
-var (
-	platform string
-	metric   int
-)
+const usage = `Usage: chnroutes <command> [flags]
 
-func init() {
-	flag.StringVar(&platform, "p", "openvpn", "Target platforms, it can be openvpn, mac, linux,win, android. openvpn by default.")
-	flag.IntVar(&metric, "m", 5, "Metric setting for the route rules")
-}
+Commands:
+  generate        write the route table of a platform (the default command)
+  fetch           download the delegation data for later use with -i
+  list-platforms  list the platforms generate supports
+  stats           summarise the delegation data and the selected routes
+  lookup          show which delegation and selection addresses belong to
+  diff            write only the changes since an earlier snapshot of the routes
+  geoip           print the entries of a V2Ray/Xray geoip.dat, to compare it with the routes
+  apply           install the routes through netlink, changing only what differs (linux)
+
+Running chnroutes with flags only, as in "chnroutes -p mac", is the same as
+"chnroutes generate -p mac". Run "chnroutes <command> -h" for its flags.
+`
 
 func main() {
-	router := map[string]func([]apnicData){
-		"openvpn": generate_open,
-		"linux":   generate_linux,
-		"mac":     generate_mac,
-		"win":     generate_win,
-		"android": generate_android,
-	}
-
-	flag.Parse()
-	if fun := router[platform]; fun != nil {
-		data := fetch_ip_data()
-		fun(data)
-	} else {
-		fmt.Printf("Platform %s is not supported.\n", platform)
+	args := os.Args[1:]
+	cmd := generate
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			cmd, args = c, args[1:]
+		} else if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+			fmt.Print(usage)
+			return
+		} else if !strings.HasPrefix(args[0], "-") {
+			fmt.Printf("Command %s is not supported.\n\n%s", args[0], usage)
+			os.Exit(-1)
+		}
+	}
+	if err := cmd(args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Println(err.Error())
+		}
+		os.Exit(-1)
 	}
 }
 
-func generate_open(data []apnicData) {
-	fp := safeCreateFile("routes.txt")
-	defer fp.Close()
+// download holds the flags that tune how sources are downloaded.
+type download struct {
+	cache   string
+	timeout time.Duration
+	retries int
+	mirrors string
+	md5     bool
+}
 
-	for _, v := range data {
-		route_item := fmt.Sprintf("route %s %s net_gateway %d\n", v.startIp, v.mask.String(), metric)
-		fp.WriteString(route_item)
+// register adds the download flags to fs, with md5 as the default of -md5.
+func (d *download) register(fs *flag.FlagSet, md5 bool) {
+	cache, err := os.UserCacheDir()
+	if err == nil {
+		cache = filepath.Join(cache, "chnroutes")
 	}
+	fs.StringVar(&d.cache, "cache", cache, "Directory downloads are cached in and revalidated with conditional requests, empty to turn the cache off")
+	fs.DurationVar(&d.timeout, "timeout", route.DefaultFetcher.Timeout, "Time limit of one download attempt")
+	fs.IntVar(&d.retries, "retries", route.DefaultFetcher.Retries, "Number of times a failed download is retried before the next mirror is tried")
+	fs.BoolVar(&d.md5, "md5", md5, "Check every download against the .md5 file published next to it, -md5=false for sources that have none")
+	fs.StringVar(&d.mirrors, "mirrors", strings.Join(route.DefaultFetcher.Mirrors, ","), "Statistics directories tried in order when a registry cannot be reached, separated by commas")
+}
 
-	fmt.Printf("Usage: Append the content of the newly created routes.txt to your openvpn config file, and also add 'max-routes %d', which takes a line, to the head of the file.\n", len(data)+20)
+// configure sets up route.DefaultFetcher, which route.Open uses.
+func (d *download) configure() {
+	f := route.DefaultFetcher
+	f.CacheDir, f.Timeout, f.Retries, f.Checksums, f.Mirrors = d.cache, d.timeout, d.retries, d.md5, nil
+	for _, m := range strings.Split(d.mirrors, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			f.Mirrors = append(f.Mirrors, m)
+		}
+	}
 }
 
-func generate_linux(data []apnicData) {
-	upfile := safeCreateFile("ip-pre-up")
-	downfile := safeCreateFile("ip-down")
-	defer upfile.Close()
-	defer downfile.Close()
+// selection holds the flags shared by every command that reads delegation
+// data.
+type selection struct {
+	download
+	input    string
+	region   string
+	exclude  string
+	groups   string
+	reserved string
+	family   string
+	status   string
+	before   string
+
+	loaded route.Groups // the groups of the -g file once read
+}
 
-	upfile.WriteString(linux_upscript_header)
-	downfile.WriteString(linux_downscript_header)
+func (s *selection) register(fs *flag.FlagSet) {
+	fs.StringVar(&s.input, "i", route.APNICURL, "Delegation data sources separated by commas, each can be a local file, - for stdin, a URL, a registry name, all for the five RIRs or nro for the NRO combined file. apnic by default.")
+	fs.StringVar(&s.region, "r", "china", "Target regions, country codes or group names joined by + or commas, such as CN+HK+MO, APAC or EU; a leading ! selects everything outside them. not-asia, asia and china still work. china by default.")
+	fs.StringVar(&s.exclude, "x", "", "Country codes or group names to leave out of the -r selection, same syntax as -r")
+	fs.StringVar(&s.groups, "g", "", "File with extra group definitions, one \"name: member member ...\" per line")
+	fs.StringVar(&s.reserved, "s", "", "File with extra special-purpose prefixes that are never routed, one prefix or address per line")
+	fs.StringVar(&s.family, "f", "both", "Address families, it can be ipv4, ipv6, both. both by default.")
+	fs.StringVar(&s.status, "status", strings.Join(route.DefaultStatuses, ","), "Delegation statuses that count, separated by commas; extended files also have available and reserved. allocated,assigned by default.")
+	fs.StringVar(&s.before, "before", "", "Only count delegations dated before this day, such as 2026-01-01, to reproduce an old table or leave out blocks too new to be routed")
+	s.download.register(fs, route.DefaultFetcher.Checksums)
+}
 
-	for _, v := range data {
-		upstr := fmt.Sprintf("route add -net %s netmask %s gw $OLDGW\n", v.startIp, v.mask.String())
-		upfile.WriteString(upstr)
-		dnstr := fmt.Sprintf("route del -net %s netmask %s\n", v.startIp, v.mask.String())
-		downfile.WriteString(dnstr)
+func (s *selection) load() (route.Dataset, error) {
+	s.configure()
+	sources := route.Sources(s.input)
+	for _, src := range sources {
+		if route.IsURL(src) {
+			fmt.Printf("Fetching data from %s...\n", src)
+		}
 	}
-	downfile.WriteString("rm /tmp/vpn_oldgw\n")
-
-	fmt.Println("For pptp only, please copy the file ip-pre-up to the folder/etc/ppp, please copy the file ip-down to the folder /etc/ppp/ip-down.d.")
+	return route.Load(sources)
 }
 
-func generate_mac(data []apnicData) {
-	upfile := safeCreateFile("ip-up")
-	downfile := safeCreateFile("ip-down")
-	defer upfile.Close()
-	defer downfile.Close()
+// loadGroups returns the built-in groups with those of the -g file, which
+// is read once.
+func (s *selection) loadGroups() (route.Groups, error) {
+	if s.groups == "" {
+		return route.DefaultGroups, nil
+	}
+	if s.loaded != nil {
+		return s.loaded, nil
+	}
+	fp, err := os.Open(s.groups)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	groups, err := route.LoadGroups(fp)
+	if err != nil {
+		if perr, ok := err.(*route.ParseError); ok {
+			perr.Source = s.groups
+		}
+		return nil, err
+	}
+	s.loaded = groups
+	return groups, nil
+}
 
-	upfile.WriteString(mac_upscript_header)
-	downfile.WriteString(mac_downscript_header)
+func (s *selection) query() (route.Query, error) {
+	groups, err := s.loadGroups()
+	if err != nil {
+		return route.Query{}, err
+	}
+	region, err := groups.Region(route.ParseSelection(s.region, s.exclude))
+	if err != nil {
+		return route.Query{}, fmt.Errorf("Region %s is not supported: %v", s.region, err)
+	}
+	switch s.family {
+	case "ipv4", "ipv6", "both":
+	default:
+		return route.Query{}, fmt.Errorf("Address family %s is not supported.", s.family)
+	}
+	reserved := route.Reserved
+	if s.reserved != "" {
+		fp, err := os.Open(s.reserved)
+		if err != nil {
+			return route.Query{}, err
+		}
+		extra, err := route.LoadPrefixes(fp)
+		fp.Close()
+		if err != nil {
+			if perr, ok := err.(*route.ParseError); ok {
+				perr.Source = s.reserved
+			}
+			return route.Query{}, err
+		}
+		reserved = append(append([]netip.Prefix{}, reserved...), extra...)
+	}
+	q := route.Query{Region: region, Family: s.family, Reserved: reserved, Statuses: []string{}}
+	for _, st := range strings.Split(s.status, ",") {
+		switch st = strings.ToLower(strings.TrimSpace(st)); st {
+		case "":
+		case route.StatusAllocated, route.StatusAssigned, route.StatusAvailable, route.StatusReserved:
+			q.Statuses = append(q.Statuses, st)
+		default:
+			return route.Query{}, fmt.Errorf("Status %s is not supported.", st)
+		}
+	}
+	if s.before != "" {
+		if q.Before, err = time.Parse("2006-01-02", s.before); err != nil {
+			return route.Query{}, fmt.Errorf("Date %s is not supported, use the form 2006-01-02.", s.before)
+		}
+	}
+	return q, nil
+}
 
-	for _, v := range data {
-		upstr := fmt.Sprintf("route add %s/%d \"${OLDGW}\"\n", v.startIp, v.maskNum)
-		upfile.WriteString(upstr)
-		dnstr := fmt.Sprintf("route delete %s/%d ${OLDGW}\n", v.startIp, v.maskNum)
-		downfile.WriteString(dnstr)
+// routes loads the data and runs the shared pipeline on it.
+func (s *selection) routes(q route.Query) ([]route.Record, []netip.Prefix, error) {
+	data, err := s.load()
+	if err != nil {
+		return nil, nil, err
 	}
-	downfile.WriteString("\n\nrm /tmp/pptp_oldgw\n")
+	records := data.Records()
+	return records, q.Routes(records), nil
+}
 
-	fmt.Println("For pptp on mac only, please copy ip-up and ip-down to the /etc/ppp folder, don't forget to make them executable with the chmod command.")
+// sets computes the routes of every code or group of the selection on its
+// own, which is nil for an inverted selection.
+func (s *selection) sets(q route.Query, records []route.Record) (map[string][]netip.Prefix, error) {
+	groups, err := s.loadGroups()
+	if err != nil {
+		return nil, err
+	}
+	regions, err := groups.Split(route.ParseSelection(s.region, s.exclude))
+	if err != nil || regions == nil {
+		return nil, err
+	}
+	sets := make(map[string][]netip.Prefix, len(regions))
+	for name, region := range regions {
+		q.Region = region
+		sets[name] = q.Routes(records)
+	}
+	return sets, nil
 }
 
-func generate_win(data []apnicData) {
-	upfile := safeCreateFile("vpnup.bat")
-	downfile := safeCreateFile("vpndown.bat")
-	defer upfile.Close()
-	defer downfile.Close()
+// target holds the flags shared by the commands that write the artifacts
+// of a platform.
+type target struct {
+	platform string
+	metric   int
+	dir      string
+	mark     uint
+	table    int
+	gw, gw6  string
+	endpoint string
+	rules    bool
+	proxy    string
+	direct   string
+}
 
-	upfile.WriteString(ms_upscript_header)
-	upfile.WriteString("ipconfig /flushdns\n\n")
-	downfile.WriteString("@echo off\n")
+func (t *target) register(fs *flag.FlagSet) {
+	fs.StringVar(&t.platform, "p", "openvpn", "Target platforms, it can be "+strings.Join(route.Platforms(), ", ")+". openvpn by default.")
+	fs.IntVar(&t.metric, "m", 5, "Metric setting for the route rules")
+	fs.StringVar(&t.dir, "o", ".", "Directory the generated files are written to")
+	fs.UintVar(&t.mark, "mark", 0, "Firewall mark given to packets to the selected addresses, such as 0x1. The nftables output writes no mark rules without it, the ipset scripts use 0x1.")
+	fs.IntVar(&t.table, "table", 0, "Routing table the iproute2 output fills, 0 for main. main by default.")
+	fs.StringVar(&t.gw, "gw", "", "Address or interface name of the ipv4 gateway for the iproute2 output, detected when the script runs by default")
+	fs.StringVar(&t.endpoint, "endpoint", "", "Address of the vpn server, left out of the wireguard AllowedIPs so that the tunnel does not carry its own packets")
+	fs.BoolVar(&t.rules, "rules", false, "Also write a configuration snippet that sends the rule set DIRECT, for the clash output")
+	fs.StringVar(&t.proxy, "proxy", "", "What the pac output answers for addresses outside the selection, such as \"PROXY proxy.example.com:3128\" or \"SOCKS5 127.0.0.1:1080; DIRECT\". "+route.DefaultPACProxy+" by default.")
+	fs.StringVar(&t.direct, "direct", "", "What the pac output answers for the selected addresses and the local networks. "+route.DefaultPACDirect+" by default.")
+	fs.StringVar(&t.gw6, "gw6", "", "Address or interface name of the ipv6 gateway for the iproute2 output, detected when the script runs by default")
+}
 
-	for _, v := range data {
-		upstr := fmt.Sprintf("route add %s mask %s %%gw%% metric %d\n", v.startIp, v.mask.String(), metric)
-		upfile.WriteString(upstr)
-		dnstr := fmt.Sprintf("route delete %s\n", v.startIp)
-		downfile.WriteString(dnstr)
+func (t *target) generator() (route.Generator, error) {
+	gen, ok := route.Lookup(t.platform)
+	if !ok {
+		return nil, fmt.Errorf("Platform %s is not supported.", t.platform)
 	}
+	return gen, nil
+}
 
-	fmt.Println("For pptp on windows only, run vpnup.bat before dialing to vpn, and run vpndown.bat after disconnected from the vpn.")
+func (t *target) options(sel selection, q route.Query) route.Options {
+	return route.Options{Metric: t.metric, List: sel.region, Family: q.Family, Reserved: q.Reserved,
+		Platform: []interface{}{
+			route.MarkOptions{Mark: uint32(t.mark)},
+			route.IPRoute2Options{Table: t.table, Gateway: t.gw, Gateway6: t.gw6},
+			route.WireguardOptions{Endpoint: t.endpoint},
+			route.ClashOptions{Rules: t.rules},
+			route.PACOptions{Proxy: t.proxy, Direct: t.direct},
+		}}
 }
 
-func generate_android(data []apnicData) {
-	upfile := safeCreateFile("vpnup.sh")
-	downfile := safeCreateFile("vpndown.sh")
-	defer upfile.Close()
-	defer downfile.Close()
+func generate(args []string) error {
+	var sel selection
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	var tgt target
+	tgt.register(fs)
+	maxRoutes := fs.Int("max-routes", 0, "Largest number of routes to write, merging or dropping routes to fit, per entry for the v2ray output. 0, the default, means no limit.")
+	absorb := fs.String("absorb", "direct", "Side that takes the addresses misclassified by -max-routes: direct merges neighbouring routes so some other addresses go direct, vpn drops the smallest routes so some selected addresses go through the vpn. direct by default.")
+	sel.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
-	upfile.WriteString(android_upscript_header)
-	downfile.WriteString(android_downscript_header)
+	gen, err := tgt.generator()
+	if err != nil {
+		return err
+	}
+	if *absorb != "direct" && *absorb != "vpn" {
+		return fmt.Errorf("Side %s is not supported.", *absorb)
+	}
+	q, err := sel.query()
+	if err != nil {
+		return err
+	}
+	records, routes, err := sel.routes(q)
+	if err != nil {
+		return err
+	}
+	what := "addresses outside the selection now go direct"
+	if *absorb == "vpn" {
+		what = "selected addresses now go through the vpn"
+	}
+	limit := func(name string, prefixes []netip.Prefix) ([]netip.Prefix, error) {
+		if *maxRoutes <= 0 || len(prefixes) <= *maxRoutes {
+			return prefixes, nil
+		}
+		cut, loss, err := route.Limit(prefixes, *maxRoutes, *absorb == "direct")
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Cut %d %s down to %d: %.0f ipv4 addresses and %.0f ipv6 /64 networks of %s.\n", len(prefixes), name, len(cut), loss.IPv4, loss.IPv6, what)
+		return cut, nil
+	}
+	opt := tgt.options(sel, q)
+	var sets map[string][]netip.Prefix
+	if _, ok := gen.(route.Splitter); ok {
+		if sets, err = sel.sets(q, records); err != nil {
+			return err
+		}
+		// Every set is written on its own, so the budget applies to each.
+		names := make([]string, 0, len(sets))
+		for name := range sets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if sets[name], err = limit("routes of "+name, sets[name]); err != nil {
+				return err
+			}
+		}
+		opt.Platform = append(opt.Platform, route.SplitOptions{Sets: sets})
+	}
+	if tunnel, ok := gen.(route.Tunnel); ok && *maxRoutes > 0 {
+		// The budget applies to the tunneled addresses, so merging them
+		// sends selected addresses through the vpn.
+		opt.Platform = append(opt.Platform, route.TunnelOptions{MaxRoutes: *maxRoutes, Widen: *absorb == "vpn"})
+		tunneled, uncut, loss, err := tunnel.Tunneled(routes, opt)
+		if err != nil {
+			return err
+		}
+		if uncut > len(tunneled) {
+			fmt.Printf("Cut %d tunneled prefixes down to %d: %.0f ipv4 addresses and %.0f ipv6 /64 networks of %s.\n", uncut, len(tunneled), loss.IPv4, loss.IPv6, what)
+		}
+	} else if sets == nil {
+		if routes, err = limit("routes", routes); err != nil {
+			return err
+		}
+	}
+	if err := route.WriteFiles(tgt.dir, gen, routes, opt); err != nil {
+		return err
+	}
+	fmt.Println(gen.Usage(routes, opt))
+	return nil
+}
 
-	for _, v := range data {
-		upstr := fmt.Sprintf("route add -net %s netmask %s gw $OLDGW\n", v.startIp, v.mask.String())
-		upfile.WriteString(upstr)
-		dnstr := fmt.Sprintf("route del -net %s netmask %s\n", v.startIp, v.mask.String())
-		downfile.WriteString(dnstr)
+// diff writes only the changes between an earlier snapshot and the current
+// selection, and prints a summary of them.
+func diff(args []string) error {
+	var sel selection
+	var tgt target
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	old := fs.String("old", "", "Earlier snapshot: a routes.txt written by the openvpn or routeos platform, a list of prefixes, or a delegated statistics file, which is run through the same selection")
+	tgt.register(fs)
+	sel.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *old == "" {
+		return fmt.Errorf("Flag -old is required.")
 	}
 
-	fmt.Println("Old school way to call up/down script from openvpn client. use the regular openvpn 2.1 method to add routes if it's possible")
-}
+	gen, err := tgt.generator()
+	if err != nil {
+		return err
+	}
+	q, err := sel.query()
+	if err != nil {
+		return err
+	}
+	sel.configure()
+	before, err := snapshot(*old, q)
+	if err != nil {
+		return err
+	}
+	_, after, err := sel.routes(q)
+	if err != nil {
+		return err
+	}
+	add, del := route.Diff(before, after)
+	if err := route.WriteUpdate(tgt.dir, gen, add, del, tgt.options(sel, q)); err != nil {
+		return err
+	}
 
-func fetch_ip_data() []apnicData {
-	// fetch data from apnic
-	fmt.Println("Fetching data from apnic.net, it might take a few minutes, please wait...")
+	count := func(prefixes []netip.Prefix) (v4 int, addrs uint64, v6 int) {
+		for _, p := range prefixes {
+			if p.Addr().Is4() {
+				v4++
+				addrs += 1 << uint(32-p.Bits())
+			} else {
+				v6++
+			}
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "\tipv4 routes\tipv4 addresses\tipv6 routes")
+	for _, row := range []struct {
+		name     string
+		prefixes []netip.Prefix
+	}{{"old", before}, {"new", after}, {"added", add}, {"removed", del}} {
+		v4, addrs, v6 := count(row.prefixes)
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", row.name, v4, addrs, v6)
+	}
+	w.Flush()
+	fmt.Printf("\n%d routes unchanged, %d to add and %d to remove.\n", len(after)-len(add), len(add), len(del))
+	return nil
+}
 
-	url := "http://ftp.apnic.net/apnic/stats/apnic/delegated-apnic-latest"
-	resp, err := http.Get(url)
+// snapshot reads the routes of an earlier run from src. A delegated
+// statistics file, told apart by its | separated records, is run through q.
+func snapshot(src string, q route.Query) ([]netip.Prefix, error) {
+	rc, err := route.Open(src)
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(-1)
+		return nil, err
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
+	var prefixes []netip.Prefix
+	if delegated(data) {
+		var records []route.Record
+		if records, err = route.Parse(bytes.NewReader(data)); err == nil {
+			prefixes = q.Routes(records)
+		}
+	} else {
+		prefixes, err = route.ParseRoutes(bytes.NewReader(data))
+	}
+	if perr, ok := err.(*route.ParseError); ok {
+		perr.Source = src
+	}
+	return prefixes, err
+}
 
-	br := bufio.NewReader(resp.Body)
-	reg, _ := regexp.Compile(`(apnic\|CN\|ipv4\|)([0-9.]*)\|([0-9]*)\|([0-9]*)\|(a.*)`)
-	results := make([]apnicData, 0)
+// delegated reports whether the first line that is not a comment looks like
+// a record of a delegated statistics file.
+func delegated(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || line[0] == '#' {
+			continue
+		}
+		return strings.Contains(line, "|")
+	}
+	return false
+}
+
+// fetch saves each source under its base name, after checking it parses,
+// so that air-gapped hosts can run generate with -i on the copies.
+func fetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	input := fs.String("i", "all", "Delegation data sources to download, same syntax as generate -i. all by default.")
+	dir := fs.String("o", ".", "Directory the downloaded files are saved to")
+	var dl download
+	dl.register(fs, route.DefaultFetcher.Checksums)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	dl.configure()
 
-	for {
-		line, isPrefix, err := br.ReadLine()
+	for _, src := range route.Sources(*input) {
+		fmt.Printf("Fetching data from %s...\n", src)
+		rc, err := route.Open(src)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		_, err = io.Copy(&buf, rc)
+		rc.Close()
 		if err != nil {
-			if err != io.EOF {
-				fmt.Println(err.Error())
-				os.Exit(-1)
+			return err
+		}
+		if _, err := route.Parse(bytes.NewReader(buf.Bytes())); err != nil {
+			if perr, ok := err.(*route.ParseError); ok {
+				perr.Source = src
 			}
-			break
+			return err
 		}
 
-		if isPrefix {
-			fmt.Println("You should not see this!")
-			return results
+		name := path.Base(src)
+		if src == "-" {
+			name = "stdin"
 		}
-
-		matches := reg.FindStringSubmatch(string(line))
-		if len(matches) != 6 {
-			continue
+		name = filepath.Join(*dir, name)
+		if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+			return &route.WriteError{Name: name, Err: err}
 		}
+		fmt.Printf("Saved %s\n", name)
+	}
+	return nil
+}
+
+func listPlatforms(args []string) error {
+	fs := flag.NewFlagSet("list-platforms", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, name := range route.Platforms() {
+		fmt.Println(name)
+	}
+	return nil
+}
 
-		starting_ip := matches[2]
-		num_ip, _ := strconv.Atoi(matches[3])
+func stats(args []string) error {
+	var sel selection
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	sel.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	q, err := sel.query()
+	if err != nil {
+		return err
+	}
+	data, err := sel.load()
+	if err != nil {
+		return err
+	}
 
-		imask := UintToIP(0xffffffff ^ uint32(num_ip-1))
-		imaskNum := 32 - int(math.Log2(float64(num_ip)))
-		// fmt.Printf("%s %v %d\n", starting_ip, imask, imaskNum)
-		results = append(results, apnicData{starting_ip, imask, imaskNum})
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "registry\tcountries\tipv4 blocks\tipv4 addresses\tipv6 blocks")
+	registries := make([]string, 0, len(data))
+	for registry := range data {
+		registries = append(registries, registry)
+	}
+	sort.Strings(registries)
+	for _, registry := range registries {
+		var v4, v6 int
+		var addrs uint64
+		for _, records := range data[registry] {
+			for _, r := range records {
+				if r.Prefix.Addr().Is4() {
+					v4++
+					addrs += 1 << uint(32-r.Prefix.Bits())
+				} else {
+					v6++
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", registry, len(data[registry]), v4, addrs, v6)
 	}
-	return results
+	w.Flush()
+
+	var v4, v6 int
+	var addrs uint64
+	for _, p := range q.Routes(data.Records()) {
+		if p.Addr().Is4() {
+			v4++
+			addrs += 1 << uint(32-p.Bits())
+		} else {
+			v6++
+		}
+	}
+	fmt.Printf("\nRegion %s: %d ipv4 routes covering %d addresses, %d ipv6 routes\n", sel.region, v4, addrs, v6)
+	return nil
 }
 
-func UintToIP(ip uint32) net.IP {
-	result := make(net.IP, 4)
-	binary.BigEndian.PutUint32([]byte(result), ip)
-	return result
+func lookup(args []string) error {
+	var sel selection
+	fs := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	sel.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: chnroutes lookup [flags] address...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	addrs := make([]netip.Addr, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		addr, err := netip.ParseAddr(arg)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, addr)
+	}
+
+	q, err := sel.query()
+	if err != nil {
+		return err
+	}
+	records, routes, err := sel.routes(q)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "address\tregistry\tcountry\tprefix\tstatus\tdate\t%s\n", sel.region)
+	for _, addr := range addrs {
+		selected := "no"
+		for _, p := range routes {
+			if p.Contains(addr) {
+				selected = "yes"
+				break
+			}
+		}
+		found := false
+		for _, r := range records {
+			if !r.Prefix.Contains(addr) {
+				continue
+			}
+			found = true
+			date := "-"
+			if !r.Date.IsZero() {
+				date = r.Date.Format("2006-01-02")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", addr, r.Registry, r.Country, r.Prefix, r.Status, date, selected)
+		}
+		if !found {
+			fmt.Fprintf(w, "%s\t-\t-\t-\tnot delegated\t-\t%s\n", addr, selected)
+		}
+	}
+	return w.Flush()
 }
 
-func safeCreateFile(name string) *os.File {
-	fp, err := os.Create(name)
+// geoip prints the prefixes of the entries of a geoip.dat in the form
+// ParseRoutes reads, so that diff -old and diff(1) can compare the file with
+// the routes.
+func geoip(args []string) error {
+	var d download
+	fs := flag.NewFlagSet("geoip", flag.ContinueOnError)
+	codes := fs.String("code", "", "Codes of the entries to print, separated by commas, such as CN,HK. All entries by default.")
+	// Files outside the statistics directories come without .md5 sums.
+	d.register(fs, false)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: chnroutes geoip [flags] file")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	d.configure()
+
+	fp, err := route.Open(fs.Arg(0))
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(-1)
+		return err
+	}
+	entries, err := route.ReadGeoIP(fp)
+	fp.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	want := make(map[string]bool)
+	for _, code := range strings.Split(*codes, ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			want[code] = true
+		}
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for _, e := range entries {
+		code := strings.ToUpper(e.Code)
+		if len(want) > 0 && !want[code] {
+			continue
+		}
+		delete(want, code)
+		reverse := ""
+		if e.ReverseMatch {
+			reverse = ", reverse match"
+		}
+		fmt.Fprintf(w, "# %s: %d prefixes%s\n", e.Code, len(e.Prefixes), reverse)
+		for _, p := range e.Prefixes {
+			fmt.Fprintln(w, p)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for code := range want {
+		return fmt.Errorf("Code %s is not in %s.", code, fs.Arg(0))
 	}
-	return fp
+	return nil
 }
@@ -0,0 +1,84 @@
+package route
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Private are the private ipv4 ranges. They are also part of Reserved.
+var Private = []netip.Prefix{
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+}
+
+var (
+	allIPv4       = netip.MustParsePrefix("0.0.0.0/0")
+	globalUnicast = netip.MustParsePrefix("2000::/3")
+)
+
+// IsPrivate reports whether addr falls in one of the Private ranges.
+func IsPrivate(addr netip.Addr) bool {
+	for _, p := range Private {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func comparePrefix(a, b netip.Prefix) int {
+	if c := a.Addr().Compare(b.Addr()); c != 0 {
+		return c
+	}
+	return a.Bits() - b.Bits()
+}
+
+// Aggregate returns the fewest aligned prefixes that cover exactly the
+// addresses of prefixes, sorted. Overlapping and adjacent prefixes are
+// merged whatever their sizes. ipv4 and ipv6 prefixes may be mixed; ipv4
+// sorts first.
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	return NewRangeSet(prefixes).Prefixes()
+}
+
+// Complement returns the largest prefixes inside universe that overlap none
+// of the excluded prefixes.
+func Complement(universe netip.Prefix, excluded []netip.Prefix) []netip.Prefix {
+	all := RangeSet{RangeOf(universe)}
+	return all.Subtract(NewRangeSet(excluded)).Prefixes()
+}
+
+// Invert returns every ipv4 address and every global unicast ipv6 address
+// that is neither in prefixes nor in reserved.
+func Invert(prefixes, reserved []netip.Prefix) []netip.Prefix {
+	excluded := append(append([]netip.Prefix{}, prefixes...), reserved...)
+	return append(Complement(allIPv4, excluded), Complement(globalUnicast, excluded)...)
+}
+
+// Diff compares two route tables prefix by prefix. It returns the prefixes
+// of want that are missing from have and those of have that are missing
+// from want, both sorted.
+func Diff(have, want []netip.Prefix) (add, del []netip.Prefix) {
+	in := func(prefixes []netip.Prefix) map[netip.Prefix]bool {
+		set := make(map[netip.Prefix]bool, len(prefixes))
+		for _, p := range prefixes {
+			set[p.Masked()] = true
+		}
+		return set
+	}
+	haveSet, wantSet := in(have), in(want)
+	for p := range wantSet {
+		if !haveSet[p] {
+			add = append(add, p)
+		}
+	}
+	for p := range haveSet {
+		if !wantSet[p] {
+			del = append(del, p)
+		}
+	}
+	sort.Slice(add, func(i, j int) bool { return comparePrefix(add[i], add[j]) < 0 })
+	sort.Slice(del, func(i, j int) bool { return comparePrefix(del[i], del[j]) < 0 })
+	return add, del
+}
@@ -0,0 +1,259 @@
+package route
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+)
+
+// Loss counts the addresses Limit moved to the wrong side of the route
+// table: ipv4 in addresses, ipv6 in /64 networks.
+type Loss struct {
+	IPv4 float64
+	IPv6 float64
+}
+
+// Limit cuts prefixes down to at most max routes. With widen, neighbouring
+// prefixes are greedily merged into their smallest common parent, cheapest
+// first, so addresses outside prefixes join the routes. Without it the
+// smallest prefixes are dropped, so addresses of prefixes leave the routes.
+// The budget is shared between the address families in proportion to their
+// number of routes.
+func Limit(prefixes []netip.Prefix, max int, widen bool) ([]netip.Prefix, Loss, error) {
+	return LimitAvoiding(prefixes, max, widen, nil)
+}
+
+// LimitAvoiding is Limit for prefixes that must never grow over the avoided
+// space, such as the local networks when the prefixes are sent through the
+// vpn. Merges that would cover any of it are not made, and when the other
+// merges do not save enough, the smallest prefixes are dropped as well.
+func LimitAvoiding(prefixes []netip.Prefix, max int, widen bool, avoid []netip.Prefix) ([]netip.Prefix, Loss, error) {
+	routes := Aggregate(prefixes)
+	if len(routes) <= max {
+		return routes, Loss{}, nil
+	}
+	if max < 1 {
+		return nil, Loss{}, fmt.Errorf("a budget of %d routes is too small", max)
+	}
+	v4 := sort.Search(len(routes), func(i int) bool { return routes[i].Addr().Is6() })
+	families := [][]netip.Prefix{routes[:v4], routes[v4:]}
+	budgets := []int{max, max}
+	if v4 > 0 && v4 < len(routes) {
+		if max < 2 {
+			return nil, Loss{}, fmt.Errorf("a budget of %d routes cannot hold both address families", max)
+		}
+		budgets[0] = max * v4 / len(routes)
+		if budgets[0] < 1 {
+			budgets[0] = 1
+		} else if budgets[0] > max-1 {
+			budgets[0] = max - 1
+		}
+		budgets[1] = max - budgets[0]
+	}
+
+	var out []netip.Prefix
+	var loss Loss
+	for i, family := range families {
+		if len(family) <= budgets[i] {
+			out = append(out, family...)
+			continue
+		}
+		var limited []netip.Prefix
+		var l Loss
+		if widen {
+			limited, l = widenTo(family, budgets[i], NewRangeSet(avoid))
+		} else {
+			limited, l = dropTo(family, budgets[i])
+		}
+		out = append(out, limited...)
+		loss.IPv4 += l.IPv4
+		loss.IPv6 += l.IPv6
+	}
+	return out, loss, nil
+}
+
+// dropTo drops the smallest prefixes until max are left.
+func dropTo(routes []netip.Prefix, max int) ([]netip.Prefix, Loss) {
+	bySize := append([]netip.Prefix(nil), routes...)
+	sort.SliceStable(bySize, func(i, j int) bool {
+		return share(bySize[i]) < share(bySize[j])
+	})
+	dropped := make(map[netip.Prefix]bool, len(routes)-max)
+	var loss Loss
+	for _, p := range bySize[:len(routes)-max] {
+		dropped[p] = true
+		loss.add(p, share(p))
+	}
+	out := make([]netip.Prefix, 0, max)
+	for _, p := range routes {
+		if !dropped[p] {
+			out = append(out, p)
+		}
+	}
+	return out, loss
+}
+
+// node is a route of the list widenTo works on.
+type node struct {
+	prefix     netip.Prefix
+	prev, next *node
+	dead       bool
+}
+
+// merge is a candidate collapse of left, right and whatever else lies in
+// their common parent.
+type merge struct {
+	left, right *node
+	parent      netip.Prefix
+	cost        float64 // address share added per route saved
+}
+
+type mergeHeap []*merge
+
+func (h mergeHeap) Len() int            { return len(h) }
+func (h mergeHeap) Less(i, j int) bool  { return h[i].cost < h[j].cost }
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*merge)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	m := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return m
+}
+
+// widenTo merges neighbouring routes, which are all of one family, until
+// max are left, without covering any of avoid.
+func widenTo(routes []netip.Prefix, max int, avoid RangeSet) ([]netip.Prefix, Loss) {
+	var head, tail *node
+	for _, p := range routes {
+		n := &node{prefix: p, prev: tail}
+		if tail == nil {
+			head = n
+		} else {
+			tail.next = n
+		}
+		tail = n
+	}
+
+	h := &mergeHeap{}
+	push := func(left *node) {
+		if left == nil || left.next == nil {
+			return
+		}
+		m := &merge{left: left, right: left.next, parent: commonParent(left.prefix, left.next.prefix)}
+		if avoid.Overlaps(RangeOf(m.parent)) {
+			return
+		}
+		m.cost, _, _, _ = m.span()
+		heap.Push(h, m)
+	}
+	for n := head; n != nil; n = n.next {
+		push(n)
+	}
+
+	var loss Loss
+	count := len(routes)
+	for count > max && h.Len() > 0 {
+		m := heap.Pop(h).(*merge)
+		if m.left.dead || m.right.dead || m.left.next != m.right {
+			continue
+		}
+		cost, first, last, saved := m.span()
+		if cost != m.cost {
+			m.cost = cost
+			heap.Push(h, m)
+			continue
+		}
+
+		n := &node{prefix: m.parent, prev: first.prev, next: last.next}
+		covered := 0.0
+		for c := first; c != last.next; c = c.next {
+			c.dead = true
+			covered += share(c.prefix)
+		}
+		loss.add(m.parent, share(m.parent)-covered)
+		if n.prev == nil {
+			head = n
+		} else {
+			n.prev.next = n
+		}
+		if n.next != nil {
+			n.next.prev = n
+		}
+		count -= saved
+		push(n.prev)
+		push(n)
+	}
+
+	out := make([]netip.Prefix, 0, count)
+	for n := head; n != nil; n = n.next {
+		out = append(out, n.prefix)
+	}
+	out = Aggregate(out)
+	if len(out) > max {
+		var dropped Loss
+		out, dropped = dropTo(out, max)
+		loss.IPv4 += dropped.IPv4
+		loss.IPv6 += dropped.IPv6
+	}
+	return out, loss
+}
+
+// span finds the routes the merge would collapse and returns its cost, the
+// first and last of them and how many routes it saves.
+func (m *merge) span() (cost float64, first, last *node, saved int) {
+	first, last = m.left, m.right
+	for first.prev != nil && m.parent.Contains(first.prev.prefix.Addr()) {
+		first = first.prev
+	}
+	for last.next != nil && m.parent.Contains(last.next.prefix.Addr()) {
+		last = last.next
+	}
+	covered := 0.0
+	for c := first; c != last.next; c = c.next {
+		covered += share(c.prefix)
+		saved++
+	}
+	saved--
+	return (share(m.parent) - covered) / float64(saved), first, last, saved
+}
+
+// commonParent returns the smallest prefix that contains both a and b,
+// which must be of the same family.
+func commonParent(a, b netip.Prefix) netip.Prefix {
+	x, y := a.Addr().As16(), b.Addr().As16()
+	bits := 0
+	for i := 0; i < 16 && x[i] == y[i]; i++ {
+		bits += 8
+	}
+	if bits < 128 {
+		for mask := byte(0x80); mask != 0 && x[bits/8]&mask == y[bits/8]&mask; mask >>= 1 {
+			bits++
+		}
+	}
+	if a.Addr().Is4() {
+		bits -= 96
+	}
+	if bits > a.Bits() {
+		bits = a.Bits()
+	}
+	if bits > b.Bits() {
+		bits = b.Bits()
+	}
+	return netip.PrefixFrom(a.Addr(), bits).Masked()
+}
+
+// share is the fraction of its family's address space p covers.
+func share(p netip.Prefix) float64 {
+	return math.Ldexp(1, -p.Bits())
+}
+
+func (l *Loss) add(p netip.Prefix, s float64) {
+	if p.Addr().Is4() {
+		l.IPv4 += math.Ldexp(s, 32)
+	} else {
+		l.IPv6 += math.Ldexp(s, 64)
+	}
+}
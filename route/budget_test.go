@@ -0,0 +1,93 @@
+package route
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+)
+
+func TestLimit(t *testing.T) {
+	in := []netip.Prefix{
+		netip.MustParsePrefix("1.0.0.0/24"),
+		netip.MustParsePrefix("1.0.2.0/24"),
+		netip.MustParsePrefix("8.0.0.0/8"),
+	}
+	got, loss, err := Limit(in, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != netip.MustParsePrefix("1.0.0.0/22") || got[1] != in[2] || loss.IPv4 != 512 {
+		t.Errorf("widen: got %v, %v", got, loss)
+	}
+
+	got, loss, err = Limit(in, 2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != in[1] || got[1] != in[2] || loss.IPv4 != 256 {
+		t.Errorf("drop: got %v, %v", got, loss)
+	}
+
+	if _, _, err := Limit(append(in, netip.MustParsePrefix("2400::/12")), 1, true); err == nil {
+		t.Error("a single route cannot hold both families")
+	}
+
+	// With 1.0.1.0/24 avoided the two /24s cannot merge, so one is dropped.
+	got, loss, err = LimitAvoiding(in, 2, true, []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != in[1] || got[1] != in[2] || loss.IPv4 != 256 {
+		t.Errorf("widen avoiding: got %v, %v", got, loss)
+	}
+}
+
+func TestLimitSample(t *testing.T) {
+	fp, err := os.Open("delegated-apnic-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := Parse(fp)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := Query{Region: Region{Countries: []string{"CN"}}}.Routes(records)
+	in := NewRangeSet(routes)
+	want4 := countIPv4(routes)
+
+	for _, max := range []int{2000, 500, 50, 2} {
+		got, loss, err := Limit(routes, max, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) > max {
+			t.Errorf("widen to %d: got %d routes", max, len(got))
+		}
+		out := NewRangeSet(got)
+		for _, r := range in {
+			if !out.Contains(r.From) || !out.Contains(r.To) {
+				t.Fatalf("widen to %d: lost %v", max, r)
+			}
+		}
+		if n := countIPv4(got) - want4; float64(n) != loss.IPv4 {
+			t.Errorf("widen to %d: %d ipv4 addresses added, loss says %.0f", max, n, loss.IPv4)
+		}
+
+		got, loss, err = Limit(routes, max, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) > max {
+			t.Errorf("drop to %d: got %d routes", max, len(got))
+		}
+		for _, p := range got {
+			if !in.Contains(p.Addr()) || !in.Contains(lastAddr(p)) {
+				t.Fatalf("drop to %d: %s was not selected", max, p)
+			}
+		}
+		if n := want4 - countIPv4(got); float64(n) != loss.IPv4 {
+			t.Errorf("drop to %d: %d ipv4 addresses removed, loss says %.0f", max, n, loss.IPv4)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+)
+
+func init() {
+	Register(clash{})
+}
+
+// clash writes rule-providers for Clash and Mihomo, once with the classical
+// behavior and once with the ipcidr one, and with ClashOptions.Rules a
+// configuration snippet that sends the routes DIRECT.
+type clash struct{}
+
+// ClashOptions are the settings of the clash generator.
+type ClashOptions struct {
+	Rules bool // add a configuration snippet using the rule set
+}
+
+func (clash) Name() string { return "clash" }
+
+func (clash) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	w, err := out.Create("clash-classical.yaml")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString("payload:\n")
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(bw, "  - IP-CIDR6,%s,no-resolve\n", p)
+			continue
+		}
+		fmt.Fprintf(bw, "  - IP-CIDR,%s,no-resolve\n", p)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	w, err = out.Create("clash-ipcidr.yaml")
+	if err != nil {
+		return err
+	}
+	bw = bufio.NewWriter(w)
+	bw.WriteString("payload:\n")
+	for _, p := range routes {
+		fmt.Fprintf(bw, "  - '%s'\n", p)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if !PlatformOptions[ClashOptions](opt).Rules {
+		return nil
+	}
+
+	w, err = out.Create("clash-rules.yaml")
+	if err != nil {
+		return err
+	}
+	bw = bufio.NewWriter(w)
+	fmt.Fprintf(bw, clashRules, identifier(opt.List))
+	return bw.Flush()
+}
+
+func (clash) Usage(routes []netip.Prefix, opt Options) string {
+	usage := "Put clash-ipcidr.yaml, or clash-classical.yaml with behavior classical, next to your Clash or Mihomo config and declare it under rule-providers."
+	if PlatformOptions[ClashOptions](opt).Rules {
+		usage += fmt.Sprintf(" clash-rules.yaml declares it as %s and sends it DIRECT ahead of the other rules, merge it into the config.", identifier(opt.List))
+	}
+	return usage
+}
+
+var clashRules = `rule-providers:
+  %[1]s:
+    type: file
+    behavior: ipcidr
+    path: ./clash-ipcidr.yaml
+
+rules:
+  - RULE-SET,%[1]s,DIRECT
+  # Everything else goes to the proxy, rename PROXY after your policy group.
+  - MATCH,PROXY
+`
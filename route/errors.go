@@ -0,0 +1,41 @@
+package route
+
+import "fmt"
+
+// NetworkError reports a failure to download a source, including a non-200
+// response and a connection dropped half way through the body.
+type NetworkError struct {
+	Source string
+	Err    error
+}
+
+func (e *NetworkError) Error() string { return fmt.Sprintf("fetch %s: %v", e.Source, e.Err) }
+func (e *NetworkError) Unwrap() error { return e.Err }
+
+// ParseError reports a malformed line of a statistics file. Line counts
+// from 1.
+type ParseError struct {
+	Source string
+	Line   int
+	Text   string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	src := e.Source
+	if src == "" {
+		src = "line"
+	}
+	return fmt.Sprintf("%s:%d: %v: %q", src, e.Line, e.Err, e.Text)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// WriteError reports a failure to write a generated artifact.
+type WriteError struct {
+	Name string
+	Err  error
+}
+
+func (e *WriteError) Error() string { return fmt.Sprintf("write %s: %v", e.Name, e.Err) }
+func (e *WriteError) Unwrap() error { return e.Err }
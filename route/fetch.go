@@ -0,0 +1,240 @@
+package route
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mirrors are the statistics directories of the other registries, each of
+// which carries a copy of the files of all five.
+var Mirrors = []string{
+	"https://ftp.ripe.net/pub/stats/",
+	"https://ftp.arin.net/pub/stats/",
+}
+
+// Fetcher downloads sources. Every URL gets Retries more attempts after a
+// failed one, and the Mirrors are tried in order once the source itself
+// gives up. The proxy is taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+type Fetcher struct {
+	// CacheDir keeps the last download of every source, which is sent again
+	// when the server answers a conditional request with 304. Empty turns
+	// the cache off.
+	CacheDir string
+	// Timeout bounds one attempt, body included. Zero means no limit.
+	Timeout time.Duration
+	Retries int
+	// Backoff is the wait before the first retry; it doubles every time.
+	Backoff time.Duration
+	// Mirrors are base URLs that replace everything up to and including
+	// "/stats/" in the path of a source.
+	Mirrors []string
+	// Checksums requires the MD5 sum the registries publish next to every
+	// file, under the same URL with .md5 appended, to match the download.
+	Checksums bool
+
+	client *http.Client
+}
+
+// DefaultFetcher is used by Open.
+var DefaultFetcher = &Fetcher{Timeout: 2 * time.Minute, Retries: 2, Backoff: time.Second, Mirrors: Mirrors, Checksums: true}
+
+// cacheEntry is stored next to a cached source and holds the validators of
+// the response it came from.
+type cacheEntry struct {
+	URL          string
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+}
+
+// Open downloads src and returns its whole body, so a broken connection is
+// reported here as a *NetworkError rather than half way through parsing.
+func (f *Fetcher) Open(src string) (io.ReadCloser, error) {
+	var entry cacheEntry
+	var cache string
+	if f.CacheDir != "" {
+		cache = filepath.Join(f.CacheDir, cacheName(src))
+		if b, err := ioutil.ReadFile(cache + ".json"); err == nil {
+			json.Unmarshal(b, &entry)
+		}
+	}
+
+	var failures []string
+	for _, u := range f.urls(src) {
+		backoff := f.Backoff
+		for attempt := 0; attempt <= f.Retries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(backoff)
+				backoff *= 2
+			}
+			var validators cacheEntry
+			if entry.URL == u {
+				validators = entry
+			}
+			body, resp, retry, err := f.get(u, validators)
+			if err == nil && resp.StatusCode == http.StatusNotModified {
+				if fp, err := os.Open(cache); err == nil {
+					return fp, nil
+				}
+				// The cached body is gone: forget its validators and
+				// download it again.
+				entry = cacheEntry{}
+				body, resp, retry, err = f.get(u, entry)
+			}
+			if err != nil {
+				if u != src {
+					err = fmt.Errorf("%s: %v", u, err)
+				}
+				failures = append(failures, err.Error())
+				if retry {
+					continue
+				}
+				break
+			}
+			if f.Checksums {
+				if retry, err = f.verify(u, body); err != nil {
+					failures = append(failures, fmt.Sprintf("%s: %v", u, err))
+					if retry {
+						continue
+					}
+					break
+				}
+			}
+			if cache != "" {
+				entry = cacheEntry{URL: u, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+				if err := store(cache, body, entry); err != nil {
+					return nil, err
+				}
+			}
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return nil, &NetworkError{src, fmt.Errorf("%s", strings.Join(failures, "; "))}
+}
+
+// get runs one attempt. It reports whether a failed attempt is worth
+// repeating, which is the case for network errors and 5xx or 429 answers.
+func (f *Fetcher) get(u string, validators cacheEntry) (body []byte, resp *http.Response, retry bool, err error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+	if f.client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyFromEnvironment
+		f.client = &http.Client{Timeout: f.Timeout, Transport: transport}
+	}
+	resp, err = f.client.Do(req)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotModified && validators.URL != "":
+		return nil, resp, false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return nil, nil, true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return nil, nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if body, err = ioutil.ReadAll(resp.Body); err != nil {
+		return nil, nil, true, err
+	}
+	return body, resp, false, nil
+}
+
+// verify checks body against the MD5 sum published at u+".md5", which
+// holds the sum either as "MD5 (name) = sum" or as "sum  name". A mismatch,
+// like a failed download of the sum, is worth another attempt.
+func (f *Fetcher) verify(u string, body []byte) (retry bool, err error) {
+	sum, _, retry, err := f.get(u+".md5", cacheEntry{})
+	if err != nil {
+		return retry, fmt.Errorf("checksum: %v", err)
+	}
+	want := ""
+	for _, field := range strings.Fields(string(sum)) {
+		if len(field) == 32 {
+			if _, err := hex.DecodeString(field); err == nil {
+				want = strings.ToLower(field)
+				break
+			}
+		}
+	}
+	if want == "" {
+		return false, fmt.Errorf("no MD5 sum in %s.md5", u)
+	}
+	if got := md5.Sum(body); hex.EncodeToString(got[:]) != want {
+		return true, fmt.Errorf("MD5 sum %x does not match %s", got, want)
+	}
+	return false, nil
+}
+
+// urls lists src followed by its copies on the mirrors.
+func (f *Fetcher) urls(src string) []string {
+	urls := []string{src}
+	i := strings.Index(src, "/stats/")
+	if i < 0 {
+		return urls
+	}
+	for _, m := range f.Mirrors {
+		if u := strings.TrimSuffix(m, "/") + "/" + src[i+len("/stats/"):]; u != src {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// cacheName turns a URL into a file name.
+func cacheName(src string) string {
+	if i := strings.Index(src, "://"); i >= 0 {
+		src = src[i+3:]
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == '?' || r == '&' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, src)
+}
+
+// store writes the body and its validators to the cache, each through a
+// temporary file so that an interrupted run leaves the old entry intact.
+func store(name string, body []byte, entry cacheEntry) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return &WriteError{Name: name, Err: err}
+	}
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{{name, body}, {name + ".json", meta}} {
+		tmp, err := writeTemp(filepath.Dir(f.name), filepath.Base(f.name), f.data)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, f.name); err != nil {
+			os.Remove(tmp)
+			return &WriteError{Name: f.name, Err: err}
+		}
+	}
+	return nil
+}
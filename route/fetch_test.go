@@ -0,0 +1,174 @@
+package route
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func fetchString(t *testing.T, f *Fetcher, src string) string {
+	rc, err := f.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestFetcherCache(t *testing.T) {
+	var hits, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"v1"` && r.Header.Get("If-Modified-Since") != "" {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte("2|apnic|20060102\n"))
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{CacheDir: t.TempDir() + "/cache"}
+	for i := 0; i < 3; i++ {
+		if got := fetchString(t, f, srv.URL+"/stats/apnic/delegated-apnic-latest"); got != "2|apnic|20060102\n" {
+			t.Errorf("fetch %d: got %q", i, got)
+		}
+	}
+	if hits != 3 || notModified != 2 {
+		t.Errorf("got %d requests of which %d conditional, want 3 and 2", hits, notModified)
+	}
+
+	// A cache entry whose body is gone is downloaded again without
+	// validators.
+	names, _ := filepath.Glob(f.CacheDir + "/*latest")
+	if len(names) != 1 {
+		t.Fatalf("cached bodies %v, want one", names)
+	}
+	os.Remove(names[0])
+	if got := fetchString(t, f, srv.URL+"/stats/apnic/delegated-apnic-latest"); got != "2|apnic|20060102\n" {
+		t.Errorf("fetch after the cached body was removed: got %q", got)
+	}
+	if hits != 5 || notModified != 3 {
+		t.Errorf("got %d requests of which %d conditional, want 5 and 3", hits, notModified)
+	}
+	if _, err := os.Stat(names[0]); err != nil {
+		t.Error("the body was not cached again")
+	}
+
+	// Without a cache no validators are sent.
+	fetchString(t, &Fetcher{}, srv.URL+"/stats/apnic/delegated-apnic-latest")
+	if notModified != 3 {
+		t.Error("conditional request sent without a cache")
+	}
+}
+
+func TestFetcherRetries(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits++; hits < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	if _, err := (&Fetcher{Retries: 1}).Open(srv.URL); err == nil {
+		t.Error("two attempts should fail")
+	}
+	hits = 0
+	if got := fetchString(t, &Fetcher{Retries: 2, Backoff: time.Millisecond}, srv.URL); got != "ok" || hits != 3 {
+		t.Errorf("got %q after %d requests", got, hits)
+	}
+}
+
+func TestFetcherMirrors(t *testing.T) {
+	var primary, first int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary++
+		http.NotFound(w, r)
+	}))
+	defer origin.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken/stats/apnic/delegated-apnic-latest":
+			first++
+			http.NotFound(w, r)
+		case "/pub/stats/apnic/delegated-apnic-latest":
+			w.Write([]byte("mirrored"))
+		default:
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+	}))
+	defer mirror.Close()
+
+	// A 404 is not retried, the next mirror is asked instead.
+	f := &Fetcher{Retries: 3, Mirrors: []string{mirror.URL + "/broken/stats/", mirror.URL + "/pub/stats"}}
+	if got := fetchString(t, f, origin.URL+"/apnic/stats/apnic/delegated-apnic-latest"); got != "mirrored" {
+		t.Errorf("got %q", got)
+	}
+	if primary != 1 || first != 1 {
+		t.Errorf("got %d and %d requests before the working mirror, want 1 and 1", primary, first)
+	}
+}
+
+func TestFetcherTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	_, err := (&Fetcher{Timeout: 20 * time.Millisecond}).Open(srv.URL)
+	if _, ok := err.(*NetworkError); !ok {
+		t.Errorf("got %v, want a *NetworkError", err)
+	}
+}
+
+func TestFetcherChecksums(t *testing.T) {
+	sums := map[string]string{
+		"/good.md5":  "MD5 (good) = 9a0364b9e99bb480dd25e1f0284c8555\n", // md5 of "content"
+		"/plain.md5": "9A0364B9E99BB480DD25E1F0284C8555  plain\n",
+		"/bad.md5":   "MD5 (bad) = 00000000000000000000000000000000\n",
+	}
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if sum, ok := sums[r.URL.Path]; ok {
+			w.Write([]byte(sum))
+			return
+		}
+		if r.URL.Path == "/missing.md5" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer srv.Close()
+
+	f := &Fetcher{Checksums: true, Retries: 1}
+	for _, name := range []string{"good", "plain"} {
+		if got := fetchString(t, f, srv.URL+"/"+name); got != "content" {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+	for _, name := range []string{"bad", "missing"} {
+		requests = 0
+		if _, err := f.Open(srv.URL + "/" + name); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+		// A wrong sum is downloaded again, a missing one is not.
+		if want := map[string]int{"bad": 4, "missing": 2}[name]; requests != want {
+			t.Errorf("%s: got %d requests, want %d", name, requests, want)
+		}
+	}
+}
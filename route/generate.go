@@ -0,0 +1,609 @@
+package route
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Options carries the settings shared by the generators.
+type Options struct {
+	Metric   int            // metric of the route rules
+	List     string         // address-list name, for routeos, or set name
+	Family   string         // "ipv4", "ipv6", or "both"/"" for both families
+	Reserved []netip.Prefix // never routed; nil means Reserved
+
+	// Platform holds the settings of single platforms, at most one value
+	// of each type, such as PACOptions or IPRoute2Options. A generator
+	// takes its own type with PlatformOptions and ignores the others, so
+	// a generator added with Register can define a type of its own.
+	Platform []interface{}
+}
+
+// PlatformOptions returns the value of type T in opt.Platform, or the zero
+// T when there is none.
+func PlatformOptions[T any](opt Options) T {
+	for _, v := range opt.Platform {
+		if t, ok := v.(T); ok {
+			return t
+		}
+	}
+	var zero T
+	return zero
+}
+
+// Splitter is implemented by generators that write one set per country
+// code or group of the selection, which they read from SplitOptions.
+// Callers fill it in for them only, since that runs the pipeline once per
+// code or group.
+type Splitter interface {
+	Generator
+	// Splits marks the generator and does nothing.
+	Splits()
+}
+
+// SplitOptions are the settings of Splitter generators.
+type SplitOptions struct {
+	// Sets holds the routes of every country code or group of the
+	// selection on its own. It is nil for an inverted selection.
+	Sets map[string][]netip.Prefix
+}
+
+// Output hands out the named artifacts a generator writes.
+type Output interface {
+	Create(name string) (io.Writer, error)
+}
+
+// Generator writes the route table of one platform.
+type Generator interface {
+	// Name is the platform name the generator is registered under.
+	Name() string
+	// Generate writes the artifacts for routes to out.
+	Generate(routes []netip.Prefix, opt Options, out Output) error
+	// Usage tells the user what to do with the artifacts.
+	Usage(routes []netip.Prefix, opt Options) string
+}
+
+// Updater is implemented by generators that can also write the commands
+// that move a live route table from one version of the routes to the next.
+type Updater interface {
+	// Update writes artifacts that remove the del prefixes and add the add
+	// prefixes.
+	Update(add, del []netip.Prefix, opt Options, out Output) error
+}
+
+// Tunnel is implemented by generators that write the addresses to send
+// through the vpn, the complement of the routes, rather than the routes.
+// They apply TunnelOptions.MaxRoutes to that list themselves, so callers
+// hand them the routes uncut.
+type Tunnel interface {
+	// Tunneled returns the list Generate writes, cut down to MaxRoutes,
+	// with the length of the list before the cut and the addresses the cut
+	// moved to the other side.
+	Tunneled(routes []netip.Prefix, opt Options) (tunneled []netip.Prefix, uncut int, loss Loss, err error)
+}
+
+// MarkOptions are the settings of the generators that mark the packets to
+// the routes, nftables and ipset, rather than route them.
+type MarkOptions struct {
+	Mark uint32 // fwmark for the selected addresses, 0 for none
+}
+
+// TunnelOptions are the settings of Tunnel generators.
+type TunnelOptions struct {
+	MaxRoutes int  // largest number of entries to write, 0 for no limit
+	Widen     bool // merge entries rather than drop them to fit MaxRoutes
+}
+
+var generators = make(map[string]Generator)
+
+// Register makes a generator available under its name. It panics if the
+// name is already taken, so an in-house generator cannot silently replace a
+// built-in one.
+func Register(g Generator) {
+	if _, dup := generators[g.Name()]; dup {
+		panic("route: Register called twice for generator " + g.Name())
+	}
+	generators[g.Name()] = g
+}
+
+// Lookup returns the generator registered under name.
+func Lookup(name string) (Generator, bool) {
+	g, ok := generators[name]
+	return g, ok
+}
+
+// Platforms returns the names of all registered generators, sorted.
+func Platforms() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MemOutput keeps every artifact in memory.
+type MemOutput map[string]*bytes.Buffer
+
+// Create implements Output.
+func (m MemOutput) Create(name string) (io.Writer, error) {
+	buf := new(bytes.Buffer)
+	m[name] = buf
+	return buf, nil
+}
+
+// WriteFiles runs g and writes its artifacts into dir. Nothing is written
+// unless the generator succeeds, and the artifacts are first written to
+// temporary files which only replace the real ones once all of them made it
+// to disk. File system failures are reported as *WriteError.
+func WriteFiles(dir string, g Generator, routes []netip.Prefix, opt Options) error {
+	out := make(MemOutput)
+	if err := g.Generate(routes, opt, out); err != nil {
+		return err
+	}
+	return writeOutput(dir, out)
+}
+
+// WriteUpdate is WriteFiles for the incremental artifacts of g, which must
+// implement Updater.
+func WriteUpdate(dir string, g Generator, add, del []netip.Prefix, opt Options) error {
+	u, ok := g.(Updater)
+	if !ok {
+		return fmt.Errorf("Platform %s cannot write incremental updates.", g.Name())
+	}
+	out := make(MemOutput)
+	if err := u.Update(add, del, opt, out); err != nil {
+		return err
+	}
+	return writeOutput(dir, out)
+}
+
+func writeOutput(dir string, out MemOutput) error {
+	temps := make(map[string]string, len(out))
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+	for name, buf := range out {
+		tmp, err := writeTemp(dir, name, buf.Bytes())
+		if err != nil {
+			return &WriteError{name, err}
+		}
+		temps[name] = tmp
+	}
+	for name, tmp := range temps {
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			return &WriteError{name, err}
+		}
+		delete(temps, name)
+	}
+	return nil
+}
+
+func writeTemp(dir, name string, data []byte) (string, error) {
+	fp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return "", err
+	}
+	if _, err = fp.Write(data); err == nil {
+		err = fp.Chmod(0644)
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fp.Name())
+		return "", err
+	}
+	return fp.Name(), nil
+}
+
+// script is an up/down pair of artifacts written through buffered writers.
+type script struct {
+	up, down *bufio.Writer
+}
+
+func createScript(out Output, upName, downName string) (*script, error) {
+	up, err := out.Create(upName)
+	if err != nil {
+		return nil, err
+	}
+	down, err := out.Create(downName)
+	if err != nil {
+		return nil, err
+	}
+	return &script{bufio.NewWriter(up), bufio.NewWriter(down)}, nil
+}
+
+func (s *script) flush() error {
+	if err := s.up.Flush(); err != nil {
+		return err
+	}
+	return s.down.Flush()
+}
+
+// writeUpdate writes header to the named artifact, then the down lines of
+// del and the up lines of add.
+func writeUpdate(out Output, name, header string, add, del []netip.Prefix, up, down func(w *bufio.Writer, p netip.Prefix)) error {
+	w, err := out.Create(name)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(header)
+	for _, p := range del {
+		down(bw, p)
+	}
+	for _, p := range add {
+		up(bw, p)
+	}
+	return bw.Flush()
+}
+
+// netmask returns the dotted netmask of an ipv4 prefix.
+func netmask(p netip.Prefix) string {
+	return net.IP(net.CIDRMask(p.Bits(), 32)).String()
+}
+
+func init() {
+	Register(openvpn{})
+	Register(linux{})
+	Register(mac{})
+	Register(win{})
+	Register(android{})
+	Register(routeos{})
+}
+
+// remove address list use `/ip firewall address-list remove [/ip firewall address-list find list="chnroutes"]`
+/*
+/ip firewall address-list add list=chnroutes address=10.0.0.0/8
+/ip firewall address-list add list=chnroutes address=172.16.0.0/12
+/ip firewall address-list add list=chnroutes address=192.168.0.0/16
+*/
+
+type routeos struct{}
+
+func (routeos) Name() string { return "routeos" }
+
+func (routeos) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	w, err := out.Create("routes.txt")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(bw, "/ipv6 firewall address-list add list=%s address=%s\n", opt.List, p)
+			continue
+		}
+		fmt.Fprintf(bw, "/ip firewall address-list add list=%s address=%s\n", opt.List, p)
+	}
+	return bw.Flush()
+}
+
+func (routeos) Usage(routes []netip.Prefix, opt Options) string {
+	return fmt.Sprintf("Paste the content of routes.txt into the routeos terminal, it creates the address-list %s.", opt.List)
+}
+
+func (routeos) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	return writeUpdate(out, "routes-update.txt", "", add, del, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "/ipv6 firewall address-list add list=%s address=%s\n", opt.List, p)
+			return
+		}
+		fmt.Fprintf(w, "/ip firewall address-list add list=%s address=%s\n", opt.List, p)
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "/ipv6 firewall address-list remove [/ipv6 firewall address-list find list=%s address=%s]\n", opt.List, p)
+			return
+		}
+		fmt.Fprintf(w, "/ip firewall address-list remove [/ip firewall address-list find list=%s address=%s]\n", opt.List, p)
+	})
+}
+
+type openvpn struct{}
+
+func (openvpn) Name() string { return "openvpn" }
+
+func (openvpn) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	w, err := out.Create("routes.txt")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(bw, "route-ipv6 %s net_gateway_ipv6 %d\n", p, opt.Metric)
+			continue
+		}
+		fmt.Fprintf(bw, "route %s %s net_gateway %d\n", p.Addr(), netmask(p), opt.Metric)
+	}
+	return bw.Flush()
+}
+
+func (openvpn) Usage(routes []netip.Prefix, opt Options) string {
+	return fmt.Sprintf("Usage: Append the content of the newly created routes.txt to your openvpn config file, and also add 'max-routes %d', which takes a line, to the head of the file.", len(routes)+20)
+}
+
+// Update lists the routes to add; openvpn has no directive that removes a
+// route, so the ones to take out of the config file are listed as comments.
+func (openvpn) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	return writeUpdate(out, "routes-update.txt", "", add, del, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "route-ipv6 %s net_gateway_ipv6 %d\n", p, opt.Metric)
+			return
+		}
+		fmt.Fprintf(w, "route %s %s net_gateway %d\n", p.Addr(), netmask(p), opt.Metric)
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "# removed: route-ipv6 %s net_gateway_ipv6 %d\n", p, opt.Metric)
+			return
+		}
+		fmt.Fprintf(w, "# removed: route %s %s net_gateway %d\n", p.Addr(), netmask(p), opt.Metric)
+	})
+}
+
+type linux struct{}
+
+func (linux) Name() string { return "linux" }
+
+func (linux) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	s, err := createScript(out, "ip-pre-up", "ip-down")
+	if err != nil {
+		return err
+	}
+
+	s.up.WriteString(linuxUpscriptHeader)
+	s.down.WriteString(linuxDownscriptHeader)
+
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(s.up, "ip -6 route add %s $OLDGW6\n", p)
+			fmt.Fprintf(s.down, "ip -6 route del %s\n", p)
+			continue
+		}
+		fmt.Fprintf(s.up, "route add -net %s netmask %s gw $OLDGW\n", p.Addr(), netmask(p))
+		fmt.Fprintf(s.down, "route del -net %s netmask %s\n", p.Addr(), netmask(p))
+	}
+	s.down.WriteString("rm /tmp/vpn_oldgw\n")
+	return s.flush()
+}
+
+func (linux) Usage(routes []netip.Prefix, opt Options) string {
+	return "For pptp only, please copy the file ip-pre-up to the folder/etc/ppp, please copy the file ip-down to the folder /etc/ppp/ip-down.d."
+}
+
+func (linux) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	return writeUpdate(out, "ip-update", linuxUpdatescriptHeader, add, del, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "ip -6 route add %s $OLDGW6\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route add -net %s netmask %s gw $OLDGW\n", p.Addr(), netmask(p))
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "ip -6 route del %s\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route del -net %s netmask %s\n", p.Addr(), netmask(p))
+	})
+}
+
+type mac struct{}
+
+func (mac) Name() string { return "mac" }
+
+func (mac) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	s, err := createScript(out, "ip-up", "ip-down")
+	if err != nil {
+		return err
+	}
+
+	s.up.WriteString(macUpscriptHeader)
+	s.down.WriteString(macDownscriptHeader)
+
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(s.up, "route add -inet6 %s \"${OLDGW6}\"\n", p)
+			fmt.Fprintf(s.down, "route delete -inet6 %s\n", p)
+			continue
+		}
+		fmt.Fprintf(s.up, "route add %s \"${OLDGW}\"\n", p)
+		fmt.Fprintf(s.down, "route delete %s ${OLDGW}\n", p)
+	}
+	s.down.WriteString("\n\nrm /tmp/pptp_oldgw\n")
+	return s.flush()
+}
+
+func (mac) Usage(routes []netip.Prefix, opt Options) string {
+	return "For pptp on mac only, please copy ip-up and ip-down to the /etc/ppp folder, don't forget to make them executable with the chmod command."
+}
+
+func (mac) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	return writeUpdate(out, "ip-update", macUpdatescriptHeader, add, del, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "route add -inet6 %s \"${OLDGW6}\"\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route add %s \"${OLDGW}\"\n", p)
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "route delete -inet6 %s\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route delete %s ${OLDGW}\n", p)
+	})
+}
+
+type win struct{}
+
+func (win) Name() string { return "win" }
+
+func (win) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	s, err := createScript(out, "vpnup.bat", "vpndown.bat")
+	if err != nil {
+		return err
+	}
+
+	s.up.WriteString(msUpscriptHeader)
+	s.up.WriteString(msScriptHeader6)
+	s.up.WriteString("ipconfig /flushdns\n\n")
+	s.down.WriteString("@echo off\n")
+	s.down.WriteString(msScriptHeader6)
+
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(s.up, "netsh interface ipv6 add route %s %%if6%% %%gw6%% metric=%d\n", p, opt.Metric)
+			fmt.Fprintf(s.down, "netsh interface ipv6 delete route %s %%if6%%\n", p)
+			continue
+		}
+		fmt.Fprintf(s.up, "route add %s mask %s %%gw%% metric %d\n", p.Addr(), netmask(p), opt.Metric)
+		fmt.Fprintf(s.down, "route delete %s\n", p.Addr())
+	}
+	return s.flush()
+}
+
+func (win) Usage(routes []netip.Prefix, opt Options) string {
+	return "For pptp on windows only, run vpnup.bat before dialing to vpn, and run vpndown.bat after disconnected from the vpn."
+}
+
+func (win) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	return writeUpdate(out, "vpnupdate.bat", msUpscriptHeader+msScriptHeader6, add, del, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "netsh interface ipv6 add route %s %%if6%% %%gw6%% metric=%d\n", p, opt.Metric)
+			return
+		}
+		fmt.Fprintf(w, "route add %s mask %s %%gw%% metric %d\n", p.Addr(), netmask(p), opt.Metric)
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "netsh interface ipv6 delete route %s %%if6%%\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route delete %s mask %s\n", p.Addr(), netmask(p))
+	})
+}
+
+type android struct{}
+
+func (android) Name() string { return "android" }
+
+func (android) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	s, err := createScript(out, "vpnup.sh", "vpndown.sh")
+	if err != nil {
+		return err
+	}
+
+	s.up.WriteString(androidUpscriptHeader)
+	s.down.WriteString(androidDownscriptHeader)
+
+	for _, p := range routes {
+		if p.Addr().Is6() {
+			fmt.Fprintf(s.up, "ip -6 route add %s $OLDGW6\n", p)
+			fmt.Fprintf(s.down, "ip -6 route del %s\n", p)
+			continue
+		}
+		fmt.Fprintf(s.up, "route add -net %s netmask %s gw $OLDGW\n", p.Addr(), netmask(p))
+		fmt.Fprintf(s.down, "route del -net %s netmask %s\n", p.Addr(), netmask(p))
+	}
+	return s.flush()
+}
+
+func (android) Usage(routes []netip.Prefix, opt Options) string {
+	return "Old school way to call up/down script from openvpn client. use the regular openvpn 2.1 method to add routes if it's possible"
+}
+
+func (android) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	return writeUpdate(out, "vpnupdate.sh", androidUpscriptHeader, add, del, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "ip -6 route add %s $OLDGW6\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route add -net %s netmask %s gw $OLDGW\n", p.Addr(), netmask(p))
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		if p.Addr().Is6() {
+			fmt.Fprintf(w, "ip -6 route del %s\n", p)
+			return
+		}
+		fmt.Fprintf(w, "route del -net %s netmask %s\n", p.Addr(), netmask(p))
+	})
+}
+
+var linuxUpscriptHeader = `#!/bin/bash
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+OLDGW=$(ip route show | grep '^default' | sed -e 's/default via \\([^ ]*\\).*/\\1/')
+if [ $OLDGW == '' ]; then
+    exit 0
+fi
+if [ ! -e /tmp/vpn_oldgw ]; then
+    echo $OLDGW > /tmp/vpn_oldgw
+fi
+OLDGW6=$(ip -6 route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+`
+
+var linuxDownscriptHeader = `#!/bin/bash
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+OLDGW=$(cat /tmp/vpn_oldgw)
+`
+
+var linuxUpdatescriptHeader = `#!/bin/bash
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+OLDGW=$(cat /tmp/vpn_oldgw)
+OLDGW6=$(ip -6 route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+`
+
+var macUpscriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+OLDGW=$(netstat -nr | grep '^default' | grep -v 'ppp' | sed 's/default *\\([0-9\.]*\\) .*/\\1/' | awk '{if($1){print $1}}')
+if [ ! -e /tmp/pptp_oldgw ]; then
+    echo "${OLDGW}" > /tmp/pptp_oldgw
+fi
+OLDGW6=$(netstat -nr -f inet6 | grep '^default' | grep -v 'ppp' | grep -v 'utun' | awk '{print $2; exit}')
+dscacheutil -flushcache
+route add 10.0.0.0/8 "${OLDGW}"
+route add 172.16.0.0/12 "${OLDGW}"
+route add 192.168.0.0/16 "${OLDGW}"
+`
+
+var macDownscriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+if [ ! -e /tmp/pptp_oldgw ]; then
+        exit 0
+fi
+ODLGW=$(cat /tmp/pptp_oldgw)
+route delete 10.0.0.0/8 "${OLDGW}"
+route delete 172.16.0.0/12 "${OLDGW}"
+route delete 192.168.0.0/16 "${OLDGW}"
+`
+
+var macUpdatescriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+OLDGW=$(cat /tmp/pptp_oldgw)
+OLDGW6=$(netstat -nr -f inet6 | grep '^default' | grep -v 'ppp' | grep -v 'utun' | awk '{print $2; exit}')
+`
+
+var msUpscriptHeader = `for /F "tokens=3" %%* in ('route print ^| findstr "\\<0.0.0.0\\>"') do set "gw=%%*"
+`
+
+var msScriptHeader6 = `for /F "tokens=1,4" %%a in ('route print -6 ^| findstr /C:" ::/0 "') do (set "if6=%%a" & set "gw6=%%b")
+`
+
+var androidUpscriptHeader = `#!/bin/sh
+alias nestat='/system/xbin/busybox netstat'
+alias grep='/system/xbin/busybox grep'
+alias awk='/system/xbin/busybox awk'
+alias route='/system/xbin/busybox route'
+OLDGW=$(netstat -rn | grep ^0\.0\.0\.0 | awk '{print $2}')
+OLDGW6=$(ip -6 route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+`
+
+var androidDownscriptHeader = `#!/bin/sh
+alias route='/system/xbin/busybox route'
+`
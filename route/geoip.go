@@ -0,0 +1,236 @@
+package route
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+func init() {
+	Register(v2ray{})
+}
+
+// GeoIP is an entry of the GeoIPList that V2Ray and Xray read from
+// geoip.dat, looked up by code as in geoip:cn.
+type GeoIP struct {
+	Code     string
+	Prefixes []netip.Prefix
+	// ReverseMatch makes the entry match every address outside Prefixes.
+	ReverseMatch bool
+}
+
+// The field numbers of the messages
+//
+//	message CIDR { bytes ip = 1; uint32 prefix = 2; }
+//	message GeoIP { string country_code = 1; repeated CIDR cidr = 2; bool reverse_match = 3; }
+//	message GeoIPList { repeated GeoIP entry = 1; }
+const (
+	fieldListEntry    = 1
+	fieldGeoIPCode    = 1
+	fieldGeoIPCIDR    = 2
+	fieldGeoIPReverse = 3
+	fieldCIDRIP       = 1
+	fieldCIDRPrefix   = 2
+)
+
+// The protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// WriteGeoIP writes the entries as a GeoIPList.
+func WriteGeoIP(w io.Writer, entries []GeoIP) error {
+	var list []byte
+	for _, e := range entries {
+		var entry []byte
+		entry = appendBytes(entry, fieldGeoIPCode, []byte(e.Code))
+		for _, p := range e.Prefixes {
+			var cidr []byte
+			cidr = appendBytes(cidr, fieldCIDRIP, p.Addr().AsSlice())
+			cidr = appendVarint(cidr, fieldCIDRPrefix, uint64(p.Bits()))
+			entry = appendBytes(entry, fieldGeoIPCIDR, cidr)
+		}
+		if e.ReverseMatch {
+			entry = appendVarint(entry, fieldGeoIPReverse, 1)
+		}
+		list = appendBytes(list, fieldListEntry, entry)
+	}
+	_, err := w.Write(list)
+	return err
+}
+
+// ReadGeoIP reads a GeoIPList. Fields it does not know are skipped, and
+// the prefixes are returned masked and in the order of the file.
+func ReadGeoIP(r io.Reader) ([]GeoIP, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []GeoIP
+	err = readFields(b, func(field int, wire int, v uint64, data []byte) error {
+		if field != fieldListEntry || wire != wireBytes {
+			return nil
+		}
+		e, err := readGeoIP(data)
+		if err != nil {
+			return fmt.Errorf("entry %d: %v", len(entries)+1, err)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, err
+}
+
+func readGeoIP(b []byte) (GeoIP, error) {
+	var e GeoIP
+	err := readFields(b, func(field int, wire int, v uint64, data []byte) error {
+		switch {
+		case field == fieldGeoIPCode && wire == wireBytes:
+			e.Code = string(data)
+		case field == fieldGeoIPCIDR && wire == wireBytes:
+			p, err := readCIDR(data)
+			if err != nil {
+				return fmt.Errorf("%s: %v", e.Code, err)
+			}
+			e.Prefixes = append(e.Prefixes, p)
+		case field == fieldGeoIPReverse && wire == wireVarint:
+			e.ReverseMatch = v != 0
+		}
+		return nil
+	})
+	return e, err
+}
+
+func readCIDR(b []byte) (netip.Prefix, error) {
+	var ip []byte
+	var bits uint64
+	err := readFields(b, func(field int, wire int, v uint64, data []byte) error {
+		switch {
+		case field == fieldCIDRIP && wire == wireBytes:
+			ip = data
+		case field == fieldCIDRPrefix && wire == wireVarint:
+			bits = v
+		}
+		return nil
+	})
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("bad address %x", ip)
+	}
+	if bits > uint64(addr.BitLen()) {
+		return netip.Prefix{}, fmt.Errorf("bad prefix length %d for %s", bits, addr)
+	}
+	return netip.PrefixFrom(addr, int(bits)).Masked(), nil
+}
+
+// readFields calls fn with the number, the wire type and the value of every
+// field of a message: v for varints and fixed-size fields, data for
+// length-delimited ones.
+func readFields(b []byte, fn func(field int, wire int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("truncated field key")
+		}
+		b = b[n:]
+		field, wire := int(key>>3), int(key&7)
+		var v uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return fmt.Errorf("truncated varint in field %d", field)
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wire, field)
+		}
+		if err := fn(field, wire, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3|wireVarint)
+	return appendUvarint(b, v)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|wireBytes)
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// v2ray writes a geoip.dat for V2Ray and Xray with one entry per country
+// code or group of the selection, or a single entry named after an inverted
+// selection.
+type v2ray struct{}
+
+func (v2ray) Name() string { return "v2ray" }
+
+func (v2ray) Splits() {}
+
+func (v2ray) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	entries := geoipEntries(routes, opt)
+	w, err := out.Create("geoip.dat")
+	if err != nil {
+		return err
+	}
+	return WriteGeoIP(w, entries)
+}
+
+func (v2ray) Usage(routes []netip.Prefix, opt Options) string {
+	var geoip, ext []string
+	for _, e := range geoipEntries(routes, opt) {
+		geoip = append(geoip, "geoip:"+strings.ToLower(e.Code))
+		ext = append(ext, "ext:chnroutes.dat:"+strings.ToLower(e.Code))
+	}
+	return fmt.Sprintf("Copy geoip.dat to the asset directory of V2Ray or Xray as chnroutes.dat and send %s to the direct outbound, or replace the geoip.dat shipped there and use %s.", strings.Join(ext, ", "), strings.Join(geoip, ", "))
+}
+
+func geoipEntries(routes []netip.Prefix, opt Options) []GeoIP {
+	sets := PlatformOptions[SplitOptions](opt).Sets
+	if sets == nil {
+		return []GeoIP{{Code: strings.ToUpper(identifier(opt.List)), Prefixes: routes}}
+	}
+	entries := make([]GeoIP, 0, len(sets))
+	for code, prefixes := range sets {
+		entries = append(entries, GeoIP{Code: code, Prefixes: prefixes})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Code < entries[j].Code })
+	return entries
+}
@@ -0,0 +1,100 @@
+package route
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestGeoIP(t *testing.T) {
+	var buf bytes.Buffer
+	cn := []GeoIP{{Code: "CN", Prefixes: []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24")}}}
+	if err := WriteGeoIP(&buf, cn); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x0a, 0x0e, // entry
+		0x0a, 0x02, 'C', 'N', // country_code
+		0x12, 0x08, // cidr
+		0x0a, 0x04, 1, 0, 1, 0, // ip
+		0x10, 24, // prefix
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got % x, want % x", buf.Bytes(), want)
+	}
+
+	entries := []GeoIP{
+		{Code: "CN", Prefixes: []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}},
+		{Code: "HK", Prefixes: []netip.Prefix{netip.MustParsePrefix("1.32.192.0/22")}},
+		{Code: "NOT_CN", Prefixes: []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24")}, ReverseMatch: true},
+	}
+	buf.Reset()
+	if err := WriteGeoIP(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadGeoIP(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("got %v, want %v", got, entries)
+	}
+
+	// Unknown fields of any wire type are skipped, and a prefix given with
+	// host bits comes back masked.
+	odd := []byte{
+		0x0a, 0x1e,
+		0x0a, 0x02, 'C', 'N',
+		0x20, 0x01, // field 4, varint
+		0x2d, 0, 0, 0, 0, // field 5, fixed32
+		0x31, 0, 0, 0, 0, 0, 0, 0, 0, // field 6, fixed64
+		0x12, 0x08,
+		0x0a, 0x04, 1, 0, 1, 9,
+		0x10, 24,
+	}
+	got, err = ReadGeoIP(bytes.NewReader(odd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, cn) {
+		t.Errorf("got %v, want %v", got, cn)
+	}
+
+	for _, bad := range [][]byte{
+		want[:len(want)-1],
+		{0x0a, 0x08, 0x12, 0x06, 0x0a, 0x02, 1, 0, 0x10, 8},
+		{0x0a, 0x08, 0x12, 0x07, 0x0a, 0x04, 1, 0, 1, 0},
+		{0x0a, 0x0a, 0x12, 0x08, 0x0a, 0x04, 1, 0, 1, 0, 0x10, 33},
+		{0x0b},
+	} {
+		if _, err := ReadGeoIP(bytes.NewReader(bad)); err == nil {
+			t.Errorf("% x: no error", bad)
+		}
+	}
+}
+
+func TestGeoIPGenerator(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("1.32.192.0/22")}
+	sets := map[string][]netip.Prefix{"HK": routes[1:], "CN": routes[:1]}
+	g, _ := Lookup("v2ray")
+	for _, tt := range []struct {
+		opt  Options
+		want []GeoIP
+	}{
+		{Options{List: "CN+HK", Platform: []interface{}{SplitOptions{Sets: sets}}}, []GeoIP{{Code: "CN", Prefixes: routes[:1]}, {Code: "HK", Prefixes: routes[1:]}}},
+		{Options{List: "!CN"}, []GeoIP{{Code: "NOT_CN", Prefixes: routes}}},
+	} {
+		out := make(MemOutput)
+		if err := g.Generate(routes, tt.opt, out); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadGeoIP(out["geoip.dat"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.opt.List, got, tt.want)
+		}
+	}
+}
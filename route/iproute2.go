@@ -0,0 +1,142 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+func init() {
+	Register(iproute2{})
+}
+
+// iproute2 writes ip -batch files that replace and delete the routes in one
+// ip process, and scripts that run them. With a table other than main, the
+// scripts add rules that make every lookup try that table before falling
+// back to the vpn routes of main.
+type iproute2 struct{}
+
+// IPRoute2Options are the settings of the iproute2 generator.
+type IPRoute2Options struct {
+	Table    int    // routing table, 0 for main
+	Gateway  string // ipv4 gateway address or interface, empty to detect
+	Gateway6 string // ipv6 gateway address or interface, empty to detect
+}
+
+func (iproute2) Name() string { return "iproute2" }
+
+func (iproute2) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	s, err := createScript(out, "routes-up.batch", "routes-down.batch")
+	if err != nil {
+		return err
+	}
+	ro := PlatformOptions[IPRoute2Options](opt)
+	table := "main"
+	if ro.Table != 0 {
+		table = fmt.Sprint(ro.Table)
+	}
+	via, via6 := nextHop(ro.Gateway, "$OLDGW"), nextHop(ro.Gateway6, "$OLDGW6")
+	for _, p := range routes {
+		hop := via
+		if p.Addr().Is6() {
+			hop = via6
+		}
+		fmt.Fprintf(s.up, "route replace %s %s table %s metric %d\n", p, hop, table, opt.Metric)
+		fmt.Fprintf(s.down, "route del %s table %s metric %d\n", p, table, opt.Metric)
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	w, err := createScript(out, "iproute2-up.sh", "iproute2-down.sh")
+	if err != nil {
+		return err
+	}
+	w.up.WriteString(iproute2UpscriptHeader)
+	w.up.WriteString("sed -e \"s|\\$OLDGW6|$OLDGW6|\" -e \"s|\\$OLDGW|$OLDGW|\" \"$(dirname \"$0\")/routes-up.batch\" | ip -batch -\n")
+	w.down.WriteString("#!/bin/sh\nexport PATH=\"/bin:/sbin:/usr/sbin:/usr/bin\"\n")
+	if ro.Table != 0 {
+		// ip -batch cannot tell an ipv6 rule from an ipv4 one, so the
+		// rules live in the scripts.
+		for _, ip := range []string{"ip", "ip -6"} {
+			fmt.Fprintf(w.up, "%s rule del lookup %s 2>/dev/null\n", ip, table)
+			fmt.Fprintf(w.up, "%s rule add lookup %s\n", ip, table)
+			fmt.Fprintf(w.down, "%s rule del lookup %s\n", ip, table)
+		}
+	}
+	w.down.WriteString("ip -force -batch \"$(dirname \"$0\")/routes-down.batch\"\n")
+	w.down.WriteString("rm -f /tmp/vpn_oldgw_iproute2\n")
+	return w.flush()
+}
+
+func (iproute2) Usage(routes []netip.Prefix, opt Options) string {
+	return "Run iproute2-up.sh before the vpn takes the default route, it loads routes-up.batch with a single ip process; iproute2-down.sh loads routes-down.batch to remove the routes again. Without -gw and -gw6 the gateways in use when the script runs are taken, and saved in /tmp/vpn_oldgw_iproute2 for iproute2-update.sh."
+}
+
+// Update writes routes-update.batch and iproute2-update.sh, which runs it
+// with -force so that a route already gone does not stop the others. The
+// vpn is up by then, so the script takes the gateways iproute2-up.sh saved
+// unless IPRoute2Options gives both.
+func (iproute2) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	ro := PlatformOptions[IPRoute2Options](opt)
+	table := "main"
+	if ro.Table != 0 {
+		table = fmt.Sprint(ro.Table)
+	}
+	via, via6 := nextHop(ro.Gateway, "$OLDGW"), nextHop(ro.Gateway6, "$OLDGW6")
+	err := writeUpdate(out, "routes-update.batch", "", add, del, func(w *bufio.Writer, p netip.Prefix) {
+		hop := via
+		if p.Addr().Is6() {
+			hop = via6
+		}
+		fmt.Fprintf(w, "route replace %s %s table %s metric %d\n", p, hop, table, opt.Metric)
+	}, func(w *bufio.Writer, p netip.Prefix) {
+		fmt.Fprintf(w, "route del %s table %s metric %d\n", p, table, opt.Metric)
+	})
+	if err != nil {
+		return err
+	}
+	w, err := out.Create("iproute2-update.sh")
+	if err != nil {
+		return err
+	}
+	header := iproute2UpdatescriptHeader
+	if ro.Gateway != "" && ro.Gateway6 != "" {
+		header = "#!/bin/sh\nexport PATH=\"/bin:/sbin:/usr/sbin:/usr/bin\"\n"
+	}
+	_, err = io.WriteString(w, header+"sed -e \"s|\\$OLDGW6|$OLDGW6|\" -e \"s|\\$OLDGW|$OLDGW|\" \"$(dirname \"$0\")/routes-update.batch\" | ip -force -batch -\n")
+	return err
+}
+
+// nextHop renders a gateway given as an address or an interface name, or the
+// placeholder the up script replaces when none is given.
+func nextHop(gw, placeholder string) string {
+	if gw == "" {
+		return placeholder
+	}
+	if _, err := netip.ParseAddr(gw); err == nil {
+		return "via " + gw
+	}
+	return "dev " + gw
+}
+
+// iproute2UpscriptHeader takes the default gateways and saves them for the
+// update script, which runs once the vpn holds the default route.
+var iproute2UpscriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+OLDGW=$(ip route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+OLDGW6=$(ip -6 route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+printf 'OLDGW="%s"\nOLDGW6="%s"\n' "$OLDGW" "$OLDGW6" > /tmp/vpn_oldgw_iproute2
+`
+
+// iproute2UpdatescriptHeader reads the gateways the up script saved, the
+// default route in use now being the vpn's.
+var iproute2UpdatescriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+if [ ! -r /tmp/vpn_oldgw_iproute2 ]; then
+    echo "No gateway saved by iproute2-up.sh, run it first or generate the update with -gw and -gw6." >&2
+    exit 1
+fi
+. /tmp/vpn_oldgw_iproute2
+`
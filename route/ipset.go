@@ -0,0 +1,127 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+)
+
+func init() {
+	Register(ipset{})
+}
+
+// ipset writes the routes as ipset restore input, which fills temporary
+// hash:net sets and swaps them with the live ones, together with scripts
+// that load the sets, mark the packets to them and route marked packets
+// through the gateway that was in use before the vpn came up.
+type ipset struct{}
+
+func (ipset) Name() string { return "ipset" }
+
+func (ipset) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	w, err := out.Create("chnroutes.ipset")
+	if err != nil {
+		return err
+	}
+	name := identifier(opt.List)
+	bw := bufio.NewWriter(w)
+	for _, family := range []string{"inet", "inet6"} {
+		set := ipsetName(name, family)
+		var prefixes []netip.Prefix
+		for _, p := range routes {
+			if p.Addr().Is4() == (family == "inet") {
+				prefixes = append(prefixes, p)
+			}
+		}
+		maxelem := 65536
+		for maxelem < len(prefixes) {
+			maxelem *= 2
+		}
+		fmt.Fprintf(bw, "create %s_tmp hash:net family %s maxelem %d\n", set, family, maxelem)
+		for _, p := range prefixes {
+			fmt.Fprintf(bw, "add %s_tmp %s\n", set, p)
+		}
+		fmt.Fprintf(bw, "swap %s_tmp %s\n", set, set)
+		fmt.Fprintf(bw, "destroy %s_tmp\n", set)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	s, err := createScript(out, "ipset-up.sh", "ipset-down.sh")
+	if err != nil {
+		return err
+	}
+	mark := PlatformOptions[MarkOptions](opt).Mark
+	if mark == 0 {
+		mark = 1
+	}
+	v4, v6 := ipsetName(name, "inet"), ipsetName(name, "inet6")
+	fmt.Fprintf(s.up, ipsetUpscriptHeader, mark)
+	fmt.Fprintf(s.down, ipsetDownscriptHeader, mark)
+	for _, family := range []string{"inet", "inet6"} {
+		set := ipsetName(name, family)
+		fmt.Fprintf(s.up, "ipset list -n %s >/dev/null 2>&1 || ipset create %s hash:net family %s\n", set, set, family)
+		fmt.Fprintf(s.up, "ipset destroy %s_tmp 2>/dev/null\n", set)
+	}
+	s.up.WriteString("ipset restore < \"$(dirname \"$0\")/chnroutes.ipset\"\n\n")
+
+	for _, t := range []struct{ cmd, ip, set, gw string }{{"iptables", "ip", v4, "OLDGW"}, {"ip6tables", "ip -6", v6, "OLDGW6"}} {
+		for _, chain := range []string{"PREROUTING", "OUTPUT"} {
+			rule := fmt.Sprintf("-t mangle %%s %s -m set --match-set %s dst -j MARK --set-mark $MARK", chain, t.set)
+			fmt.Fprintf(s.up, "%s %s 2>/dev/null || %s %s\n", t.cmd, fmt.Sprintf(rule, "-C"), t.cmd, fmt.Sprintf(rule, "-A"))
+			fmt.Fprintf(s.down, "%s %s\n", t.cmd, fmt.Sprintf(rule, "-D"))
+		}
+		fmt.Fprintf(s.up, "if [ -n \"$%s\" ]; then\n", t.gw)
+		fmt.Fprintf(s.up, "    %s route replace default $%s table $TABLE\n", t.ip, t.gw)
+		fmt.Fprintf(s.up, "    %s rule del fwmark $MARK table $TABLE 2>/dev/null\n", t.ip)
+		fmt.Fprintf(s.up, "    %s rule add fwmark $MARK table $TABLE\n", t.ip)
+		s.up.WriteString("fi\n")
+		fmt.Fprintf(s.down, "%s rule del fwmark $MARK table $TABLE\n", t.ip)
+		fmt.Fprintf(s.down, "%s route flush table $TABLE\n", t.ip)
+	}
+	fmt.Fprintf(s.down, "ipset destroy %s\nipset destroy %s\n", v4, v6)
+	return s.flush()
+}
+
+func (ipset) Usage(routes []netip.Prefix, opt Options) string {
+	return "Run ipset-up.sh, next to chnroutes.ipset, before the vpn takes the default route, and run it again to update the sets in place; ipset-down.sh removes everything. Set MARK and TABLE in the environment to change the fwmark and the routing table."
+}
+
+func (ipset) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	name := identifier(opt.List)
+	entry := func(verb string) func(w *bufio.Writer, p netip.Prefix) {
+		return func(w *bufio.Writer, p netip.Prefix) {
+			family := "inet"
+			if p.Addr().Is6() {
+				family = "inet6"
+			}
+			fmt.Fprintf(w, "%s %s %s\n", verb, ipsetName(name, family), p)
+		}
+	}
+	return writeUpdate(out, "chnroutes-update.ipset", "", add, del, entry("add"), entry("del"))
+}
+
+// ipsetName is the name of the set holding one family of the routes.
+func ipsetName(name, family string) string {
+	if family == "inet6" {
+		return name + "6"
+	}
+	return name
+}
+
+var ipsetUpscriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+MARK=${MARK:-%#x}
+TABLE=${TABLE:-100}
+OLDGW=$(ip route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+OLDGW6=$(ip -6 route show default | head -n 1 | sed -e 's/^default \(via [^ ]* \)\{0,1\}\(dev [^ ]*\).*/\1\2/')
+
+`
+
+var ipsetDownscriptHeader = `#!/bin/sh
+export PATH="/bin:/sbin:/usr/sbin:/usr/bin"
+MARK=${MARK:-%#x}
+TABLE=${TABLE:-100}
+
+`
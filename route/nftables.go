@@ -0,0 +1,106 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+func init() {
+	Register(nftables{})
+}
+
+// nftables writes an nft -f file holding the routes in two interval sets of
+// the inet table named after opt.List. The sets are flushed and refilled in
+// the same transaction, so loading the file again swaps the content
+// atomically.
+type nftables struct{}
+
+func (nftables) Name() string { return "nftables" }
+
+func (nftables) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	w, err := out.Create("chnroutes.nft")
+	if err != nil {
+		return err
+	}
+	name, mark := identifier(opt.List), PlatformOptions[MarkOptions](opt).Mark
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#!/usr/sbin/nft -f\n\ntable inet %s {\n", name)
+	fmt.Fprintf(bw, "\tset %s_v4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n", name)
+	fmt.Fprintf(bw, "\tset %s_v6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t}\n", name)
+	if mark != 0 {
+		bw.WriteString("\tchain prerouting {\n\t\ttype filter hook prerouting priority mangle; policy accept;\n\t}\n")
+		bw.WriteString("\tchain output {\n\t\ttype route hook output priority mangle; policy accept;\n\t}\n")
+	}
+	bw.WriteString("}\n\n")
+
+	for _, family := range []string{"v4", "v6"} {
+		fmt.Fprintf(bw, "flush set inet %s %s_%s\n", name, name, family)
+	}
+	for _, family := range []string{"v4", "v6"} {
+		first := true
+		for _, p := range routes {
+			if p.Addr().Is4() != (family == "v4") {
+				continue
+			}
+			if first {
+				fmt.Fprintf(bw, "add element inet %s %s_%s {\n", name, name, family)
+				first = false
+			}
+			fmt.Fprintf(bw, "\t%s,\n", p)
+		}
+		if !first {
+			bw.WriteString("}\n")
+		}
+	}
+
+	if mark != 0 {
+		for _, chain := range []string{"prerouting", "output"} {
+			fmt.Fprintf(bw, "\nflush chain inet %s %s\n", name, chain)
+			fmt.Fprintf(bw, "add rule inet %s %s ip daddr @%s_v4 meta mark set %#x\n", name, chain, name, mark)
+			fmt.Fprintf(bw, "add rule inet %s %s ip6 daddr @%s_v6 meta mark set %#x\n", name, chain, name, mark)
+		}
+	}
+	return bw.Flush()
+}
+
+func (nftables) Usage(routes []netip.Prefix, opt Options) string {
+	name, mark := identifier(opt.List), PlatformOptions[MarkOptions](opt).Mark
+	usage := fmt.Sprintf("Load the sets with 'nft -f chnroutes.nft', run it again to replace their content in one transaction. Match them in your own rules with 'ip daddr @%s_v4' and 'ip6 daddr @%s_v6' of table inet %s.", name, name, name)
+	if mark != 0 {
+		usage += fmt.Sprintf(" Packets to the sets get the fwmark %#x, route them with 'ip rule add fwmark %#x table <table>' and 'ip -6 rule add fwmark %#x table <table>'.", mark, mark, mark)
+	}
+	return usage
+}
+
+// Update deletes and adds the changed elements in one transaction, the
+// table and the sets must already exist.
+func (nftables) Update(add, del []netip.Prefix, opt Options, out Output) error {
+	name := identifier(opt.List)
+	element := func(verb string) func(w *bufio.Writer, p netip.Prefix) {
+		return func(w *bufio.Writer, p netip.Prefix) {
+			family := "v4"
+			if p.Addr().Is6() {
+				family = "v6"
+			}
+			fmt.Fprintf(w, "%s element inet %s %s_%s { %s }\n", verb, name, name, family, p)
+		}
+	}
+	return writeUpdate(out, "chnroutes-update.nft", "#!/usr/sbin/nft -f\n\n", add, del, element("add"), element("delete"))
+}
+
+// identifier turns a list name such as "CN+HK" or "!ASIA" into a name nft
+// and ipset accept.
+func identifier(s string) string {
+	if s == "" {
+		return "chnroutes"
+	}
+	s = strings.Replace(s, "!", "not_", -1)
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,160 @@
+package route
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+)
+
+func init() {
+	Register(pac{})
+}
+
+// The answers of the PAC file when PACOptions leaves them empty.
+const (
+	DefaultPACProxy  = "PROXY 127.0.0.1:8080"
+	DefaultPACDirect = "DIRECT"
+)
+
+// pac writes a proxy auto-config file that sends the routes and the local
+// networks direct and everything else through the proxy. The ipv4 routes
+// are embedded as a sorted table of ranges the script searches with a
+// binary search; PAC scripts only see the ipv4 address dnsResolve returns,
+// so ipv6 routes are left out.
+type pac struct{}
+
+// PACOptions are the settings of the pac generator: the answers of the
+// file, such as "PROXY host:3128" and "DIRECT".
+type PACOptions struct {
+	Proxy  string
+	Direct string
+}
+
+func (pac) Name() string { return "pac" }
+
+func (pac) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	po := PlatformOptions[PACOptions](opt)
+	proxy, direct := po.Proxy, po.Direct
+	if proxy == "" {
+		proxy = DefaultPACProxy
+	}
+	if direct == "" {
+		direct = DefaultPACDirect
+	}
+	reserved := opt.Reserved
+	if reserved == nil {
+		reserved = Reserved
+	}
+
+	w, err := out.Create("proxy.pac")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, pacHeader, strconv.Quote(proxy), strconv.Quote(direct))
+	bw.WriteString("var routes = [")
+	writeRanges(bw, routes)
+	bw.WriteString("];\nvar local = [")
+	writeRanges(bw, reserved)
+	bw.WriteString("];\n")
+	bw.WriteString(pacLookup)
+	return bw.Flush()
+}
+
+func (pac) Usage(routes []netip.Prefix, opt Options) string {
+	usage := "Serve proxy.pac over http and set its URL as the automatic proxy configuration of the browser or the system."
+	if PlatformOptions[PACOptions](opt).Proxy == "" {
+		usage += " Set -proxy to your proxy, such as \"PROXY proxy.example.com:3128\" or \"SOCKS5 127.0.0.1:1080; DIRECT\", it is " + DefaultPACProxy + " now."
+	}
+	return usage
+}
+
+// writeRanges writes the ipv4 addresses of the prefixes as a flat list of
+// merged inclusive ranges, first and last address as integers.
+func writeRanges(bw *bufio.Writer, prefixes []netip.Prefix) {
+	for i, r := range pacRanges(prefixes) {
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		fmt.Fprintf(bw, "%d,%d", r[0], r[1])
+	}
+}
+
+// pacRanges returns the merged ipv4 ranges of the prefixes in address order.
+func pacRanges(prefixes []netip.Prefix) [][2]uint32 {
+	var ranges [][2]uint32
+	for _, r := range NewRangeSet(prefixes) {
+		if !r.From.Is4() {
+			continue
+		}
+		from, to := r.From.As4(), r.To.As4()
+		ranges = append(ranges, [2]uint32{binary.BigEndian.Uint32(from[:]), binary.BigEndian.Uint32(to[:])})
+	}
+	return ranges
+}
+
+var pacHeader = `// Generated by chnroutes. The addresses in routes, plain host names and the
+// local networks go direct, everything else goes through the proxy.
+var proxy = %s;
+var direct = %s;
+
+// Inclusive ranges of ipv4 addresses as integers, in order:
+// first, last, first, last, ...
+`
+
+var pacLookup = `
+function FindProxyForURL(url, host) {
+	if (isPlainHostName(host)) {
+		return direct;
+	}
+	var ip = host;
+	if (!/^\d+\.\d+\.\d+\.\d+$/.test(host)) {
+		ip = dnsResolve(host);
+		if (!ip) {
+			return proxy;
+		}
+	}
+	var n = ipToInteger(ip);
+	if (n < 0) {
+		return proxy;
+	}
+	if (inRanges(local, n) || inRanges(routes, n)) {
+		return direct;
+	}
+	return proxy;
+}
+
+// ipToInteger returns the dotted ipv4 address ip as an integer, or -1.
+function ipToInteger(ip) {
+	var parts = ip.split(".");
+	if (parts.length != 4) {
+		return -1;
+	}
+	var n = 0;
+	for (var i = 0; i < 4; i++) {
+		var b = parseInt(parts[i], 10);
+		if (isNaN(b) || b < 0 || b > 255) {
+			return -1;
+		}
+		n = n * 256 + b;
+	}
+	return n;
+}
+
+// inRanges finds the last range that starts at or before n with a binary
+// search and reports whether it reaches n.
+function inRanges(ranges, n) {
+	var lo = 0, hi = ranges.length / 2 - 1;
+	while (lo <= hi) {
+		var mid = Math.floor((lo + hi) / 2);
+		if (ranges[2 * mid] <= n) {
+			lo = mid + 1;
+		} else {
+			hi = mid - 1;
+		}
+	}
+	return hi >= 0 && n <= ranges[2 * hi + 1];
+}
+`
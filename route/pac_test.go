@@ -0,0 +1,103 @@
+package route
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var pacRoutes = Aggregate([]netip.Prefix{
+	netip.MustParsePrefix("1.0.1.0/24"),
+	netip.MustParsePrefix("1.0.2.0/23"),
+	netip.MustParsePrefix("1.0.8.0/21"),
+	netip.MustParsePrefix("36.0.0.0/10"),
+	netip.MustParsePrefix("223.255.252.0/23"),
+	netip.MustParsePrefix("2001:250::/31"),
+})
+
+func pacScript(t *testing.T) string {
+	g, _ := Lookup("pac")
+	out := make(MemOutput)
+	if err := g.Generate(pacRoutes, Options{Platform: []interface{}{PACOptions{Proxy: `SOCKS5 "a":1080; DIRECT`}}}, out); err != nil {
+		t.Fatal(err)
+	}
+	return out["proxy.pac"].String()
+}
+
+func TestPAC(t *testing.T) {
+	script := pacScript(t)
+	for _, want := range []string{`var proxy = "SOCKS5 \"a\":1080; DIRECT";`, `var direct = "DIRECT";`, "function FindProxyForURL(url, host) {"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("proxy.pac lacks %s", want)
+		}
+	}
+	m := regexp.MustCompile(`(?m)^var routes = \[([0-9,]*)\];$`).FindStringSubmatch(script)
+	if m == nil || len(strings.Split(m[1], ",")) != 8 {
+		t.Errorf("got routes table %q, want 8 numbers for the 4 merged ranges", m)
+	}
+}
+
+// TestPACScript runs the generated file with node, which stands in for the
+// browser, and checks its answer for both edges of every range and the
+// addresses around them.
+func TestPACScript(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	var hosts []string
+	for _, p := range append(append([]netip.Prefix{}, pacRoutes...), Reserved...) {
+		if !p.Addr().Is4() {
+			continue
+		}
+		r := RangeOf(p)
+		for _, addr := range []netip.Addr{r.From, r.To, r.From.Prev(), r.To.Next()} {
+			if addr.Is4() {
+				hosts = append(hosts, addr.String())
+			}
+		}
+	}
+	hosts = append(hosts, "8.8.8.8", "255.255.255.255", "intranet", "www.example.cn", "unresolved.example.com", "1.2.3")
+	want := make([]string, len(hosts))
+	direct := NewRangeSet(append(append([]netip.Prefix{}, pacRoutes...), Reserved...))
+	for i, host := range hosts {
+		want[i] = `SOCKS5 "a":1080; DIRECT`
+		if addr, err := netip.ParseAddr(host); err == nil && direct.Contains(addr) {
+			want[i] = "DIRECT"
+		}
+	}
+	want[len(hosts)-4] = "DIRECT" // a plain host name
+	want[len(hosts)-3] = "DIRECT" // resolves to 1.0.1.1
+
+	list, _ := json.Marshal(hosts)
+	harness := `
+function isPlainHostName(host) { return host.indexOf(".") < 0; }
+function dnsResolve(host) { return host == "www.example.cn" ? "1.0.1.1" : null; }
+` + "var hosts = " + string(list) + `;
+for (var i = 0; i < hosts.length; i++) {
+	console.log(FindProxyForURL("http://" + hosts[i] + "/", hosts[i]));
+}
+`
+	file := filepath.Join(t.TempDir(), "proxy.js")
+	if err := os.WriteFile(file, []byte(pacScript(t)+harness), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(node, file).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	if len(got) != len(hosts) {
+		t.Fatalf("got %d answers for %d hosts: %s", len(got), len(hosts), out)
+	}
+	for i, host := range hosts {
+		if got[i] != want[i] {
+			t.Errorf("%s: got %s, want %s", host, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package route
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Range is an inclusive range of addresses of one family.
+type Range struct {
+	From, To netip.Addr
+}
+
+// RangeOf returns the addresses covered by p.
+func RangeOf(p netip.Prefix) Range {
+	p = p.Masked()
+	return Range{p.Addr(), lastAddr(p)}
+}
+
+// Prefixes decomposes r into the fewest aligned CIDR blocks, in address
+// order.
+func (r Range) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	from := r.From
+	for from.IsValid() && from.Compare(r.To) <= 0 {
+		bits := 0
+		for ; bits < from.BitLen(); bits++ {
+			p := netip.PrefixFrom(from, bits)
+			if p.Masked().Addr() == from && lastAddr(p).Compare(r.To) <= 0 {
+				break
+			}
+		}
+		p := netip.PrefixFrom(from, bits)
+		prefixes = append(prefixes, p)
+		from = lastAddr(p).Next()
+	}
+	return prefixes
+}
+
+// RangeSet is a set of addresses kept as sorted ranges that neither overlap
+// nor touch. ipv4 ranges sort before ipv6 ones.
+type RangeSet []Range
+
+// NewRangeSet returns the set of addresses covered by any of the prefixes.
+func NewRangeSet(prefixes []netip.Prefix) RangeSet {
+	ranges := make([]Range, 0, len(prefixes))
+	for _, p := range prefixes {
+		ranges = append(ranges, RangeOf(p))
+	}
+	return MergeRanges(ranges)
+}
+
+// MergeRanges sorts the ranges and merges those that overlap or are
+// adjacent. Ranges of different families are never merged.
+func MergeRanges(ranges []Range) RangeSet {
+	sorted := append([]Range(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].From.Compare(sorted[j].From) < 0
+	})
+
+	set := make(RangeSet, 0, len(sorted))
+	for _, r := range sorted {
+		if n := len(set); n > 0 && set[n-1].From.BitLen() == r.From.BitLen() {
+			last := &set[n-1]
+			if r.From.Compare(last.To) <= 0 || last.To.Next() == r.From {
+				if r.To.Compare(last.To) > 0 {
+					last.To = r.To
+				}
+				continue
+			}
+		}
+		set = append(set, r)
+	}
+	return set
+}
+
+// Contains reports whether addr is in the set.
+func (s RangeSet) Contains(addr netip.Addr) bool {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].To.Compare(addr) >= 0
+	})
+	return i < len(s) && s[i].From.Compare(addr) <= 0
+}
+
+// Overlaps reports whether any address of r is in s.
+func (s RangeSet) Overlaps(r Range) bool {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].To.Compare(r.From) >= 0
+	})
+	return i < len(s) && s[i].From.Compare(r.To) <= 0
+}
+
+// Subtract returns the addresses of s that are not in o.
+func (s RangeSet) Subtract(o RangeSet) RangeSet {
+	var out RangeSet
+	j := 0
+	for _, r := range s {
+		for j < len(o) && o[j].To.Compare(r.From) < 0 {
+			j++
+		}
+		from := r.From
+		for k := j; k < len(o) && o[k].From.Compare(r.To) <= 0; k++ {
+			if o[k].From.Compare(from) > 0 {
+				out = append(out, Range{from, o[k].From.Prev()})
+			}
+			if o[k].To.Compare(r.To) >= 0 {
+				from = netip.Addr{}
+				break
+			}
+			from = o[k].To.Next()
+		}
+		if from.IsValid() {
+			out = append(out, Range{from, r.To})
+		}
+	}
+	return out
+}
+
+// Prefixes decomposes the set into the fewest aligned CIDR blocks.
+func (s RangeSet) Prefixes() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, r := range s {
+		prefixes = append(prefixes, r.Prefixes()...)
+	}
+	return prefixes
+}
+
+// lastAddr returns the highest address of p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	bits := p.Bits()
+	if p.Addr().Is4() {
+		b := p.Addr().As4()
+		for i := bits; i < 32; i++ {
+			b[i/8] |= 0x80 >> uint(i%8)
+		}
+		return netip.AddrFrom4(b)
+	}
+	b := p.Addr().As16()
+	for i := bits; i < 128; i++ {
+		b[i/8] |= 0x80 >> uint(i%8)
+	}
+	return netip.AddrFrom16(b)
+}
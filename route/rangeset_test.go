@@ -0,0 +1,151 @@
+package route
+
+import (
+	"math/rand"
+	"net/netip"
+	"os"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+func TestRangePrefixes(t *testing.T) {
+	// Every range of ipv4 addresses decomposes into aligned blocks that
+	// tile it exactly, and no two neighbouring blocks are siblings.
+	f := func(from, n uint32) bool {
+		if uint64(from)+uint64(n) > 1<<32-1 {
+			n = ^from
+		}
+		r := Range{addr4(uint64(from)), addr4(uint64(from) + uint64(n))}
+		prefixes := r.Prefixes()
+		next := uint64(from)
+		for i, p := range prefixes {
+			if p != p.Masked() || ipv4(p.Addr()) != next {
+				return false
+			}
+			if i > 0 && p.Bits() == prefixes[i-1].Bits() && netip.PrefixFrom(prefixes[i-1].Addr(), p.Bits()-1).Contains(p.Addr()) {
+				return false
+			}
+			next += 1 << uint(32-p.Bits())
+		}
+		return next == uint64(from)+uint64(n)+1
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAggregateSample(t *testing.T) {
+	fp, err := os.Open("delegated-apnic-latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := Parse(fp)
+	fp.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := Prefixes(records)
+
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 10; round++ {
+		// Take a random subset, split some blocks and repeat others, so
+		// the input overlaps, touches and comes in any order.
+		var in []netip.Prefix
+		for _, p := range all {
+			if rnd.Intn(4) == 0 {
+				continue
+			}
+			if rnd.Intn(8) == 0 && p.Bits() < p.Addr().BitLen() {
+				in = append(in, netip.PrefixFrom(p.Addr(), p.Bits()+1))
+			}
+			in = append(in, p)
+		}
+		rnd.Shuffle(len(in), func(i, j int) { in[i], in[j] = in[j], in[i] })
+
+		out := Aggregate(in)
+		for i, p := range out {
+			if p != p.Masked() {
+				t.Fatalf("round %d: %s is not aligned", round, p)
+			}
+			if i == 0 {
+				continue
+			}
+			prev := out[i-1]
+			if comparePrefix(prev, p) >= 0 || prev.Overlaps(p) {
+				t.Fatalf("round %d: %s and %s are out of order or overlap", round, prev, p)
+			}
+			if prev.Bits() == p.Bits() && p.Bits() > 0 && netip.PrefixFrom(prev.Addr(), p.Bits()-1).Masked().Contains(p.Addr()) {
+				t.Fatalf("round %d: siblings %s and %s were not merged", round, prev, p)
+			}
+		}
+		if again := Aggregate(out); len(again) != len(out) {
+			t.Fatalf("round %d: aggregating twice gave %d prefixes, want %d", round, len(again), len(out))
+		}
+
+		set := NewRangeSet(out)
+		for _, p := range in {
+			if !set.Contains(p.Addr()) || !set.Contains(lastAddr(p)) {
+				t.Fatalf("round %d: %s is not covered", round, p)
+			}
+		}
+		var got uint64
+		for _, p := range out {
+			if p.Addr().Is4() {
+				got += 1 << uint(32-p.Bits())
+			}
+		}
+		if want := countIPv4(in); got != want {
+			t.Fatalf("round %d: output covers %d ipv4 addresses, want %d", round, got, want)
+		}
+	}
+}
+
+func TestRangeSetSubtract(t *testing.T) {
+	s := NewRangeSet([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")})
+	o := NewRangeSet([]netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("10.255.255.255/32"), netip.MustParsePrefix("2001:db8::/33")})
+	got := s.Subtract(o).Prefixes()
+	want := []string{"10.0.0.0/16", "10.2.0.0/15", "10.4.0.0/14", "10.8.0.0/13", "10.16.0.0/12", "10.32.0.0/11", "10.64.0.0/10", "10.128.0.0/10", "10.192.0.0/11", "10.224.0.0/12", "10.240.0.0/13", "10.248.0.0/14", "10.252.0.0/15", "10.254.0.0/16", "10.255.0.0/17", "10.255.128.0/18", "10.255.192.0/19", "10.255.224.0/20", "10.255.240.0/21", "10.255.248.0/22", "10.255.252.0/23", "10.255.254.0/24", "10.255.255.0/25", "10.255.255.128/26", "10.255.255.192/27", "10.255.255.224/28", "10.255.255.240/29", "10.255.255.248/30", "10.255.255.252/31", "10.255.255.254/32", "2001:db8:8000::/33"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("prefix %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+}
+
+// countIPv4 counts the ipv4 addresses covered by prefixes by sweeping over
+// their sorted ranges.
+func countIPv4(prefixes []netip.Prefix) uint64 {
+	type span struct{ from, to uint64 }
+	var spans []span
+	for _, p := range prefixes {
+		if p.Addr().Is4() {
+			from := ipv4(p.Masked().Addr())
+			spans = append(spans, span{from, from + 1<<uint(32-p.Bits())})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].from < spans[j].from })
+	var total, end uint64
+	for _, s := range spans {
+		if s.from < end {
+			s.from = end
+		}
+		if s.to > s.from {
+			total += s.to - s.from
+			end = s.to
+		}
+	}
+	return total
+}
+
+func ipv4(a netip.Addr) uint64 {
+	b := a.As4()
+	return uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3])
+}
+
+func addr4(ip uint64) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
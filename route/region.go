@@ -0,0 +1,287 @@
+package route
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Region is a resolved set of countries. An inverted region stands for
+// every address outside those countries.
+type Region struct {
+	Countries []string
+	Invert    bool
+}
+
+// Groups maps a group name to its members, which are country codes or the
+// names of other groups. Names are upper case.
+type Groups map[string][]string
+
+// DefaultGroups are the groups known without a groups file. ASIA is the
+// country list the asia and not-asia regions always used.
+var DefaultGroups = Groups{
+	"ASIA": {"CN", "MN", "KP", "KR", "JP", "VN", "LA", "KH", "TH", "MM", "MY", "SG", "ID", "BN", "PH", "TL", "IN", "BD", "BT", "NP", "PK", "LK", "MV", "SA", "AE", "TR", "LB", "IQ", "IR", "AF", "TW"},
+	// APAC is the APNIC service region.
+	"APAC": {"AF", "AS", "AU", "BD", "BN", "BT", "CC", "CK", "CN", "CX", "FJ", "FM", "GU", "HK", "ID", "IN", "IO", "JP", "KH", "KI", "KP", "KR", "LA", "LK", "MH", "MM", "MN", "MO", "MP", "MV", "MY", "NC", "NF", "NP", "NR", "NU", "NZ", "PF", "PG", "PH", "PK", "PN", "PW", "SB", "SG", "TH", "TK", "TL", "TO", "TV", "TW", "VN", "VU", "WF", "WS"},
+	// EU holds the member states plus the EU code RIPE NCC uses for
+	// pan-European delegations.
+	"EU": {"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK", "EU"},
+}
+
+// Selection picks countries by code or group name. Invert selects every
+// address outside the resulting countries.
+type Selection struct {
+	Include []string
+	Exclude []string
+	Invert  bool
+}
+
+// legacySelections keeps the region names of the old -r flag working.
+var legacySelections = map[string]Selection{
+	"china":    {Include: []string{"CN"}},
+	"asia":     {Include: []string{"ASIA"}, Exclude: []string{"CN"}},
+	"not-asia": {Include: []string{"ASIA"}, Invert: true},
+}
+
+// ParseSelection parses an include list such as "CN+HK+MO", "APAC,EU" or
+// "!ASIA" (everything outside Asia), and an exclude list in the same
+// syntax without the "!". The old region names china, asia and not-asia
+// are accepted as include lists.
+func ParseSelection(include, exclude string) Selection {
+	sel, ok := legacySelections[include]
+	if !ok {
+		sel.Invert = strings.HasPrefix(include, "!")
+		sel.Include = splitTerms(strings.TrimPrefix(include, "!"))
+	}
+	sel.Exclude = append(append([]string{}, sel.Exclude...), splitTerms(exclude)...)
+	return sel
+}
+
+func splitTerms(spec string) []string {
+	return strings.FieldsFunc(strings.ToUpper(spec), func(r rune) bool {
+		return r == '+' || r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// Region resolves the selection against the groups: the countries of every
+// included code or group, minus those of every excluded one. An inverted
+// selection leaves out the excluded countries as well, so its region holds
+// both.
+func (g Groups) Region(sel Selection) (Region, error) {
+	include, err := g.expand(sel.Include)
+	if err != nil {
+		return Region{}, err
+	}
+	exclude, err := g.expand(sel.Exclude)
+	if err != nil {
+		return Region{}, err
+	}
+	var countries []string
+	for cc := range include {
+		if !exclude[cc] || sel.Invert {
+			countries = append(countries, cc)
+		}
+	}
+	if sel.Invert {
+		for cc := range exclude {
+			if !include[cc] {
+				countries = append(countries, cc)
+			}
+		}
+	}
+	sort.Strings(countries)
+	return Region{Countries: countries, Invert: sel.Invert}, nil
+}
+
+// Split resolves every included code or group of the selection on its own,
+// minus the excluded ones, keyed by the upper case term. An inverted
+// selection stands for the space outside all of them and is not split, so it
+// gives nil.
+func (g Groups) Split(sel Selection) (map[string]Region, error) {
+	if sel.Invert {
+		return nil, nil
+	}
+	regions := make(map[string]Region)
+	for _, term := range sel.Include {
+		r, err := g.Region(Selection{Include: []string{term}, Exclude: sel.Exclude})
+		if err != nil {
+			return nil, err
+		}
+		regions[strings.ToUpper(term)] = r
+	}
+	return regions, nil
+}
+
+// expand returns the set of country codes the terms stand for.
+func (g Groups) expand(terms []string) (map[string]bool, error) {
+	set := make(map[string]bool)
+	var walk func(term string, path []string) error
+	walk = func(term string, path []string) error {
+		term = strings.ToUpper(term)
+		// A group may list the country code of its own name, as EU does.
+		if n := len(path); n > 0 && path[n-1] == term && isCountryCode(term) {
+			set[term] = true
+			return nil
+		}
+		members, ok := g[term]
+		if !ok {
+			if !isCountryCode(term) {
+				return fmt.Errorf("unknown country or group %q", term)
+			}
+			set[term] = true
+			return nil
+		}
+		for _, name := range path {
+			if name == term {
+				return fmt.Errorf("group %s includes itself", term)
+			}
+		}
+		for _, m := range members {
+			if err := walk(m, append(path, term)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, term := range terms {
+		if err := walk(term, nil); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && s[0] >= 'A' && s[0] <= 'Z' && s[1] >= 'A' && s[1] <= 'Z'
+}
+
+// LoadGroups reads group definitions on top of DefaultGroups. Each line is
+// "name: member member ...", members being country codes or group names;
+// blank lines and lines starting with # are ignored. A later definition
+// replaces an earlier one of the same name.
+func LoadGroups(r io.Reader) (Groups, error) {
+	groups := make(Groups, len(DefaultGroups))
+	for name, members := range DefaultGroups {
+		groups[name] = members
+	}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i <= 0 {
+			return nil, &ParseError{Line: line, Text: scanner.Text(), Err: errors.New("want name: members")}
+		}
+		name := strings.ToUpper(strings.TrimSpace(text[:i]))
+		groups[name] = splitTerms(text[i+1:])
+	}
+	return groups, scanner.Err()
+}
+
+// Filter returns the records for which keep returns true.
+func Filter(records []Record, keep func(Record) bool) []Record {
+	var out []Record
+	for _, r := range records {
+		if keep(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// InCountries returns a Filter predicate matching records delegated to any
+// of the given country codes.
+func InCountries(codes ...string) func(Record) bool {
+	set := make(map[string]bool, len(codes))
+	for _, cc := range codes {
+		set[cc] = true
+	}
+	return func(r Record) bool {
+		return set[r.Country]
+	}
+}
+
+// WithStatus returns a Filter predicate matching records of any of the given
+// statuses, and records without a status.
+func WithStatus(statuses ...string) func(Record) bool {
+	set := make(map[string]bool, len(statuses))
+	for _, s := range statuses {
+		set[strings.ToLower(s)] = true
+	}
+	return func(r Record) bool {
+		return r.Status == "" || set[r.Status]
+	}
+}
+
+// Before returns a Filter predicate matching records delegated before t.
+// Records without a date match, since nothing tells they are newer.
+func Before(t time.Time) func(Record) bool {
+	return func(r Record) bool {
+		return r.Date.IsZero() || r.Date.Before(t)
+	}
+}
+
+// DefaultStatuses are the statuses of the space actually delegated to an
+// organisation.
+var DefaultStatuses = []string{StatusAllocated, StatusAssigned}
+
+// IPv4 and IPv6 are Filter predicates selecting one address family.
+func IPv4(r Record) bool { return r.Prefix.Addr().Is4() }
+func IPv6(r Record) bool { return r.Prefix.Addr().Is6() }
+
+// Query describes which routes to compute from a set of records.
+type Query struct {
+	Region   Region
+	Family   string         // "ipv4", "ipv6", or "both"/"" for both families
+	Reserved []netip.Prefix // never routed; nil means the Reserved list
+	Statuses []string       // statuses that count; nil means DefaultStatuses
+	Before   time.Time      // only delegations before it count; zero means all
+}
+
+// Routes runs the shared pipeline: keep the records of the region that have
+// one of the statuses and predate the cutoff, drop reserved space and
+// aggregate. An inverted region yields everything outside
+// it and the reserved space instead. The result is finally limited to the
+// query's address family.
+func (q Query) Routes(records []Record) []netip.Prefix {
+	reserved := q.Reserved
+	if reserved == nil {
+		reserved = Reserved
+	}
+	statuses := q.Statuses
+	if statuses == nil {
+		statuses = DefaultStatuses
+	}
+	inRegion, withStatus := InCountries(q.Region.Countries...), WithStatus(statuses...)
+	before := func(Record) bool { return true }
+	if !q.Before.IsZero() {
+		before = Before(q.Before)
+	}
+	records = Filter(records, func(r Record) bool {
+		return inRegion(r) && withStatus(r) && before(r)
+	})
+	set := NewRangeSet(Prefixes(records)).Subtract(NewRangeSet(reserved))
+	prefixes := set.Prefixes()
+	if q.Region.Invert {
+		prefixes = Invert(prefixes, reserved)
+	}
+
+	out := prefixes[:0]
+	for _, p := range prefixes {
+		if (q.Family == "ipv4" && !p.Addr().Is4()) || (q.Family == "ipv6" && !p.Addr().Is6()) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
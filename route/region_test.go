@@ -0,0 +1,108 @@
+package route
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupsRegion(t *testing.T) {
+	groups, err := LoadGroups(strings.NewReader(`
+# greater china
+gc: CN HK MO TW
+east-asia: gc JP KR
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		include, exclude string
+		want             Region
+	}{
+		{"CN+HK+MO", "", Region{Countries: []string{"CN", "HK", "MO"}}},
+		{"east-asia", "tw,KR", Region{Countries: []string{"CN", "HK", "JP", "MO"}}},
+		{"!gc", "", Region{Countries: []string{"CN", "HK", "MO", "TW"}, Invert: true}},
+		{"!gc", "JP,tw", Region{Countries: []string{"CN", "HK", "JP", "MO", "TW"}, Invert: true}},
+		{"china", "", Region{Countries: []string{"CN"}}},
+		{"not-asia", "", Region{Countries: groupCountries("ASIA"), Invert: true}},
+		{"EU", "FR", Region{Countries: []string{"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "EU", "FI", "GR", "HR", "HU", "IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK"}}},
+	}
+	for _, tt := range tests {
+		got, err := groups.Region(ParseSelection(tt.include, tt.exclude))
+		if err != nil {
+			t.Errorf("%s -%s: %v", tt.include, tt.exclude, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s -%s: got %+v, want %+v", tt.include, tt.exclude, got, tt.want)
+		}
+	}
+
+	if _, err := groups.Region(ParseSelection("CHINA-ISH", "")); err == nil {
+		t.Error("an unknown name should be an error")
+	}
+	loop, _ := LoadGroups(strings.NewReader("a: b\nb: a\n"))
+	if _, err := loop.Region(ParseSelection("a", "")); err == nil {
+		t.Error("a group including itself should be an error")
+	}
+}
+
+func groupCountries(name string) []string {
+	region, _ := DefaultGroups.Region(Selection{Include: []string{name}})
+	return region.Countries
+}
+
+func TestGroupsSplit(t *testing.T) {
+	regions, err := DefaultGroups.Split(ParseSelection("CN+HK,EU", "FR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(regions["CN"].Countries, regions["HK"].Countries, len(regions)); got != "[CN] [HK] 3" {
+		t.Errorf("got %s", got)
+	}
+	for _, cc := range regions["EU"].Countries {
+		if cc == "FR" {
+			t.Error("EU keeps the excluded FR")
+		}
+	}
+	if regions, err := DefaultGroups.Split(ParseSelection("!CN", "")); regions != nil || err != nil {
+		t.Errorf("inverted selection split into %v, %v", regions, err)
+	}
+	if _, err := DefaultGroups.Split(ParseSelection("CN+XYZ", "")); err == nil {
+		t.Error("unknown group split without error")
+	}
+}
+
+func TestQueryStatusDate(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	records := []Record{
+		{Country: "CN", Prefix: netip.MustParsePrefix("1.0.1.0/24"), Status: StatusAllocated, Date: day("2011-04-14")},
+		{Country: "CN", Prefix: netip.MustParsePrefix("1.0.2.0/24"), Status: StatusAssigned, Date: day("2025-12-31")},
+		{Country: "CN", Prefix: netip.MustParsePrefix("1.0.3.0/24"), Status: StatusAllocated, Date: day("2026-01-01")},
+		{Country: "CN", Prefix: netip.MustParsePrefix("1.0.4.0/24"), Status: StatusReserved},
+		{Country: "CN", Prefix: netip.MustParsePrefix("1.0.5.0/24"), Status: StatusAvailable},
+	}
+	cn := Region{Countries: []string{"CN"}}
+	tests := []struct {
+		q    Query
+		want string
+	}{
+		{Query{Region: cn}, "[1.0.1.0/24 1.0.2.0/23]"},
+		{Query{Region: cn, Before: day("2026-01-01")}, "[1.0.1.0/24 1.0.2.0/24]"},
+		{Query{Region: cn, Statuses: []string{StatusAllocated}}, "[1.0.1.0/24 1.0.3.0/24]"},
+		{Query{Region: cn, Statuses: []string{StatusAllocated, StatusAssigned, StatusReserved}, Before: day("2012-01-01")}, "[1.0.1.0/24 1.0.4.0/24]"},
+		{Query{Region: cn, Statuses: []string{StatusReserved, StatusAvailable}}, "[1.0.4.0/23]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(tt.q.Routes(records)); got != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.q, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package route
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// Reserved are the blocks of the IANA IPv4 and IPv6 special-purpose address
+// registries, plus the multicast ranges. They are left out of every route
+// table, inverted or not. Programs may append to it; the -s flag of
+// chnroutes adds the prefixes of a file.
+var Reserved = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),         // this network
+	netip.MustParsePrefix("10.0.0.0/8"),        // private use
+	netip.MustParsePrefix("100.64.0.0/10"),     // shared address space
+	netip.MustParsePrefix("127.0.0.0/8"),       // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),    // link local
+	netip.MustParsePrefix("172.16.0.0/12"),     // private use
+	netip.MustParsePrefix("192.0.0.0/24"),      // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),      // documentation
+	netip.MustParsePrefix("192.31.196.0/24"),   // AS112-v4
+	netip.MustParsePrefix("192.52.193.0/24"),   // AMT
+	netip.MustParsePrefix("192.88.99.0/24"),    // deprecated 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),    // private use
+	netip.MustParsePrefix("192.175.48.0/24"),   // direct delegation AS112 service
+	netip.MustParsePrefix("198.18.0.0/15"),     // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"),   // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),    // documentation
+	netip.MustParsePrefix("224.0.0.0/4"),       // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),       // reserved, limited broadcast
+	netip.MustParsePrefix("::/128"),            // unspecified
+	netip.MustParsePrefix("::1/128"),           // loopback
+	netip.MustParsePrefix("::ffff:0:0/96"),     // ipv4-mapped
+	netip.MustParsePrefix("64:ff9b::/96"),      // ipv4-ipv6 translation
+	netip.MustParsePrefix("64:ff9b:1::/48"),    // local-use ipv4-ipv6 translation
+	netip.MustParsePrefix("100::/64"),          // discard-only
+	netip.MustParsePrefix("2001::/23"),         // IETF protocol assignments
+	netip.MustParsePrefix("2001:db8::/32"),     // documentation
+	netip.MustParsePrefix("2002::/16"),         // 6to4
+	netip.MustParsePrefix("2620:4f:8000::/48"), // direct delegation AS112 service
+	netip.MustParsePrefix("3fff::/20"),         // documentation
+	netip.MustParsePrefix("5f00::/16"),         // segment routing SIDs
+	netip.MustParsePrefix("fc00::/7"),          // unique local
+	netip.MustParsePrefix("fe80::/10"),         // link local
+	netip.MustParsePrefix("ff00::/8"),          // multicast
+}
+
+// LoadPrefixes reads one prefix or address per line, as in a file of extra
+// reserved ranges. Blank lines and text after # are ignored.
+func LoadPrefixes(r io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(text)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(text)
+			if aerr != nil {
+				return nil, &ParseError{Line: line, Text: scanner.Text(), Err: err}
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, scanner.Err()
+}
@@ -0,0 +1,66 @@
+package route
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestQueryReserved(t *testing.T) {
+	records := []Record{
+		{Country: "CN", Prefix: netip.MustParsePrefix("1.0.1.0/24")},
+		{Country: "CN", Prefix: netip.MustParsePrefix("100.64.0.0/16")},
+		{Country: "CN", Prefix: netip.MustParsePrefix("2001:250::/35")},
+	}
+
+	inverted := Query{Region: Region{Countries: []string{"CN"}, Invert: true}}.Routes(records)
+	set := NewRangeSet(inverted)
+	for _, s := range []string{"0.1.2.3", "1.0.1.1", "10.1.1.1", "100.64.0.1", "127.0.0.1", "169.254.1.1", "192.0.2.1", "224.0.0.1", "255.255.255.255", "2001:250::1", "2001:db8::1", "2002::1", "fe80::1", "ff02::1"} {
+		if set.Contains(netip.MustParseAddr(s)) {
+			t.Errorf("inverted selection routes %s", s)
+		}
+	}
+	for _, s := range []string{"1.0.0.1", "8.8.8.8", "223.255.255.255", "2400:cb00::1"} {
+		if !set.Contains(netip.MustParseAddr(s)) {
+			t.Errorf("inverted selection misses %s", s)
+		}
+	}
+
+	// Excluding a country from an inverted selection keeps it out too.
+	region, err := DefaultGroups.Region(ParseSelection("!CN", "JP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jp := append(records, Record{Country: "JP", Prefix: netip.MustParsePrefix("1.0.16.0/20")})
+	set = NewRangeSet(Query{Region: region}.Routes(jp))
+	if set.Contains(netip.MustParseAddr("1.0.16.1")) || set.Contains(netip.MustParseAddr("1.0.1.1")) || !set.Contains(netip.MustParseAddr("8.8.8.8")) {
+		t.Errorf("!CN -x JP routes %v", set.Prefixes())
+	}
+
+	extra := append(append([]netip.Prefix{}, Reserved...), netip.MustParsePrefix("1.0.1.128/25"))
+	got := Query{Region: Region{Countries: []string{"CN"}}, Family: "ipv4", Reserved: extra}.Routes(records)
+	if len(got) != 1 || got[0] != netip.MustParsePrefix("1.0.1.0/25") {
+		t.Errorf("selection with extra reserved space: got %v", got)
+	}
+}
+
+func TestLoadPrefixes(t *testing.T) {
+	got, err := LoadPrefixes(strings.NewReader("# corporate ranges\n1.2.3.0/24\n\n  5.6.7.8 # jump host\n2400:1::/32\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.2.3.0/24", "5.6.7.8/32", "2400:1::/32"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("prefix %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	_, err = LoadPrefixes(strings.NewReader("1.2.3.0/24\n1.2.3\n"))
+	if perr, ok := err.(*ParseError); !ok || perr.Line != 2 {
+		t.Errorf("got %v, want a parse error on line 2", err)
+	}
+}
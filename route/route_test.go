@@ -1,56 +1,334 @@
 // route_test.go
-package main
+package route
 
 import (
+	"bufio"
+	"fmt"
+	"net/netip"
 	"os"
-	"regexp"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestIspravite(t *testing.T) {
-	classA := "10.1.1.5"
-	classB := "172.25.255.1"
-	classC := "192.168.65.5"
-	pub := "1.0.5.255"
-	if !Ispravite(classA) {
+	classA := netip.MustParseAddr("10.1.1.5")
+	classB := netip.MustParseAddr("172.25.255.1")
+	classC := netip.MustParseAddr("192.168.65.5")
+	pub := netip.MustParseAddr("1.0.5.255")
+	if !IsPrivate(classA) {
 		t.Log("class A:", classA, "should be pravite")
 		t.Fail()
 	}
-	if !Ispravite(classB) {
+	if !IsPrivate(classB) {
 		t.Log("class B:", classB, "should be pravite")
 		t.Fail()
 	}
-	if !Ispravite(classC) {
+	if !IsPrivate(classC) {
 		t.Log("class C:", classC, "should be pravite")
 		t.Fail()
 	}
-	if Ispravite(pub) {
+	if IsPrivate(pub) {
 		t.Log("pub:", pub, "should be public")
 		t.Fail()
 	}
 }
 
 func TestIsInAsia(t *testing.T) {
-	b := "apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated"
-	c := "apnic|AU|ipv4|1.0.0.0|256|20110811|assigned"
-	var reg = regexp.MustCompile(reg_comp_as)
-	matches := reg.FindStringSubmatch(b)
-	if matches == nil {
+	b, _ := ParseLine("apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated")
+	c, _ := ParseLine("apnic|AU|ipv4|1.0.0.0|256|20110811|assigned")
+	asia, _ := DefaultGroups.Region(ParseSelection("asia", ""))
+	inAsia := InCountries(asia.Countries...)
+	if len(b) != 1 || !inAsia(b[0]) {
 		t.Fail()
 	}
-	matches = reg.FindStringSubmatch(c)
-	if matches != nil {
+	if len(c) != 1 || inAsia(c[0]) {
 		t.Fail()
 	}
 }
 
-func TestSafeCreateFile(t *testing.T) {
-	n := "Helloword"
-	safeCreateFile(n)
-	_, err := os.Stat(n)
+func TestParseLine(t *testing.T) {
+	records, err := ParseLine("apnic|CN|ipv4|1.0.1.0|768|20110414|allocated")
 	if err != nil {
-		t.Log("Don't create the file")
-		t.Fail()
+		t.Fatal(err)
+	}
+	want := []string{"1.0.1.0/24", "1.0.2.0/23"}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i, r := range records {
+		if r.Prefix.String() != want[i] || r.Country != "CN" || r.Registry != "apnic" {
+			t.Errorf("record %d: got %+v, want %s", i, r, want[i])
+		}
+	}
+
+	records, err = ParseLine("apnic|CN|ipv6|2001:250::|31|20000426|allocated")
+	if err != nil || len(records) != 1 || records[0].Prefix != netip.MustParsePrefix("2001:250::/31") {
+		t.Errorf("ipv6: got %+v", records)
+	}
+
+	for _, line := range []string{"2|apnic|20160323|44500|19850701|20160322|+1000", "apnic|*|ipv4|*|33077|summary", "apnic|JP|asn|173|1|20020801|allocated"} {
+		if records, err := ParseLine(line); records != nil || err != nil {
+			t.Errorf("%q: got %+v, %v, want nothing", line, records, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	in := "2|apnic|20160323|44500|19850701|20160322|+1000\n" +
+		"apnic|CN|ipv4|1.0.1.0|256|20110414|allocated\n" +
+		"apnic|CN|ipv4|1.0.2.0|25"
+	records, err := Parse(strings.NewReader(in))
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("got %v, want a *ParseError", err)
+	}
+	if perr.Line != 3 {
+		t.Errorf("got line %d, want 3", perr.Line)
+	}
+	if records != nil {
+		t.Errorf("got %d records together with an error", len(records))
+	}
+
+	_, err = Parse(strings.NewReader("apnic|CN|ipv4|1.0.1.0|" + strings.Repeat("1", bufio.MaxScanTokenSize) + "\n"))
+	if perr, ok := err.(*ParseError); !ok || perr.Line != 1 {
+		t.Errorf("overlong line: got %v, want a *ParseError on line 1", err)
+	}
+}
+
+func TestParseCounts(t *testing.T) {
+	body := "apnic|*|ipv4|*|2|summary\napnic|*|ipv6|*|1|summary\n" +
+		"apnic|CN|ipv4|1.0.1.0|256|20110414|allocated\napnic|CN|ipv4|1.0.2.0|512|20110414|allocated\n" +
+		"apnic|CN|ipv6|2001:250::|31|20000426|allocated\n"
+	header := "2|apnic|20160323|%d|19850701|20160322|+1000\n"
+	if records, err := Parse(strings.NewReader(fmt.Sprintf(header, 3) + body)); err != nil || len(records) != 3 {
+		t.Errorf("got %d records and %v", len(records), err)
+	}
+
+	for _, c := range []struct {
+		in   string
+		line int
+	}{
+		{fmt.Sprintf(header, 4) + body, 1},
+		{fmt.Sprintf(header, 3) + strings.Join(strings.SplitAfter(body, "\n")[:4], ""), 1},
+		{strings.Join(strings.SplitAfter(body, "\n")[:4], ""), 2},
+		{strings.Replace(body, "|2|summary", "|3|summary", 1), 1},
+	} {
+		_, err := Parse(strings.NewReader(c.in))
+		if perr, ok := err.(*ParseError); !ok || perr.Line != c.line {
+			t.Errorf("%q: got %v, want a parse error on line %d", c.in, err, c.line)
+		}
+	}
+
+	h, err := ParseHeader("2.3|arin|1700000000000|10|19700101|00000000|-0500")
+	if err != nil || h.Version != "2.3" || h.Registry != "arin" || h.Records != 10 || h.StartDate.Year() != 1970 || !h.EndDate.IsZero() {
+		t.Errorf("got %+v and %v", h, err)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	in := []netip.Prefix{
+		netip.MustParsePrefix("1.0.2.0/24"),
+		netip.MustParsePrefix("1.0.3.0/24"),
+		netip.MustParsePrefix("1.0.2.128/25"),
+		netip.MustParsePrefix("1.0.1.0/24"),
+	}
+	got := Aggregate(in)
+	want := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("1.0.2.0/23")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestComplement(t *testing.T) {
+	got := Complement(netip.MustParsePrefix("10.0.0.0/8"), []netip.Prefix{netip.MustParsePrefix("10.128.0.0/9")})
+	if len(got) != 1 || got[0] != netip.MustParsePrefix("10.0.0.0/9") {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	g, _ := Lookup("linux")
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}
+	if err := WriteFiles(dir, g, routes, Options{Metric: 5}); err != nil {
+		t.Fatal(err)
+	}
+	err := WriteFiles(filepath.Join(dir, "missing"), g, routes, Options{Metric: 5})
+	if _, ok := err.(*WriteError); !ok {
+		t.Errorf("got %v, want a *WriteError", err)
+	}
+	for _, n := range []string{"ip-pre-up", "ip-down"} {
+		if _, err := os.Stat(filepath.Join(dir, n)); err != nil {
+			t.Log("Don't create the file", n)
+			t.Fail()
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("got %d entries, want only the two scripts", len(entries))
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}
+	want := map[string]map[string]string{
+		"openvpn": {"routes.txt": "route 1.0.1.0 255.255.255.0 net_gateway 5\nroute-ipv6 2001:250::/31 net_gateway_ipv6 5\n"},
+		"nftables": {"chnroutes.nft": "#!/usr/sbin/nft -f\n\ntable inet chnroutes {\n\tset chnroutes_v4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t}\n\tset chnroutes_v6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t}\n}\n\n" +
+			"flush set inet chnroutes chnroutes_v4\nflush set inet chnroutes chnroutes_v6\nadd element inet chnroutes chnroutes_v4 {\n\t1.0.1.0/24,\n}\nadd element inet chnroutes chnroutes_v6 {\n\t2001:250::/31,\n}\n"},
+		"ipset": {"chnroutes.ipset": "create chnroutes_tmp hash:net family inet maxelem 65536\nadd chnroutes_tmp 1.0.1.0/24\nswap chnroutes_tmp chnroutes\ndestroy chnroutes_tmp\n" +
+			"create chnroutes6_tmp hash:net family inet6 maxelem 65536\nadd chnroutes6_tmp 2001:250::/31\nswap chnroutes6_tmp chnroutes6\ndestroy chnroutes6_tmp\n"},
+		"iproute2": {
+			"routes-up.batch":   "route replace 1.0.1.0/24 $OLDGW table main metric 5\nroute replace 2001:250::/31 $OLDGW6 table main metric 5\n",
+			"routes-down.batch": "route del 1.0.1.0/24 table main metric 5\nroute del 2001:250::/31 table main metric 5\n",
+		},
+		"clash": {
+			"clash-classical.yaml": "payload:\n  - IP-CIDR,1.0.1.0/24,no-resolve\n  - IP-CIDR6,2001:250::/31,no-resolve\n",
+			"clash-ipcidr.yaml":    "payload:\n  - '1.0.1.0/24'\n  - '2001:250::/31'\n",
+		},
+		"sing-box": {
+			"rule-set.json": "{\n  \"version\": 1,\n  \"rules\": [\n    {\n      \"ip_cidr\": [\n        \"1.0.1.0/24\",\n        \"2001:250::/31\"\n      ]\n    }\n  ]\n}\n",
+		},
+		"routeos": {"routes.txt": "/ip firewall address-list add list=chnroutes address=1.0.1.0/24\n/ipv6 firewall address-list add list=chnroutes address=2001:250::/31\n"},
+	}
+	for name, files := range want {
+		g, ok := Lookup(name)
+		if !ok {
+			t.Fatalf("generator %s is not registered", name)
+		}
+		out := make(MemOutput)
+		if err := g.Generate(routes, Options{Metric: 5, List: "chnroutes"}, out); err != nil {
+			t.Fatal(err)
+		}
+		for file, content := range files {
+			if got := out[file].String(); got != content {
+				t.Errorf("%s %s: got %q, want %q", name, file, got, content)
+			}
+		}
+	}
+}
+
+func TestWinHeaders(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}
+	g, _ := Lookup("win")
+	out := make(MemOutput)
+	if err := g.Generate(routes, Options{Metric: 5}, out); err != nil {
+		t.Fatal(err)
+	}
+	gw := `for /F "tokens=3" %%* in ('route print ^| findstr "\\<0.0.0.0\\>"') do set "gw=%%*"`
+	gw6 := `for /F "tokens=1,4" %%a in ('route print -6 ^| findstr /C:" ::/0 "') do (set "if6=%%a" & set "gw6=%%b")`
+	want := map[string][]string{
+		"vpnup.bat":   {gw, gw6, "ipconfig /flushdns", "", "route add 1.0.1.0 mask 255.255.255.0 %gw% metric 5", "netsh interface ipv6 add route 2001:250::/31 %if6% %gw6% metric=5"},
+		"vpndown.bat": {"@echo off", gw6, "route delete 1.0.1.0", "netsh interface ipv6 delete route 2001:250::/31 %if6%"},
+	}
+	for file, lines := range want {
+		if got := strings.Split(strings.TrimSuffix(out[file].String(), "\n"), "\n"); !reflect.DeepEqual(got, lines) {
+			t.Errorf("%s: got %q, want %q", file, got, lines)
+		}
+	}
+}
+
+func TestClashRules(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24")}
+	g, _ := Lookup("clash")
+	out := make(MemOutput)
+	if err := g.Generate(routes, Options{List: "CN+HK"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out["clash-rules.yaml"]; ok {
+		t.Error("clash-rules.yaml written without Rules")
 	}
 
+	out = make(MemOutput)
+	if err := g.Generate(routes, Options{List: "CN+HK", Platform: []interface{}{ClashOptions{Rules: true}}}, out); err != nil {
+		t.Fatal(err)
+	}
+	rules := out["clash-rules.yaml"].String()
+	for _, want := range []string{"  CN_HK:\n    type: file\n    behavior: ipcidr\n", "  - RULE-SET,CN_HK,DIRECT\n"} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("clash-rules.yaml lacks %q:\n%s", want, rules)
+		}
+	}
+}
+
+func TestWireguard(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}
+	g, _ := Lookup("wireguard")
+	tunnel := g.(Tunnel)
+
+	endpoint := WireguardOptions{Endpoint: "93.184.216.34:51820"}
+	opt := Options{Platform: []interface{}{endpoint}}
+	allowed, uncut, _, err := tunnel.Tunneled(routes, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uncut != len(allowed) {
+		t.Errorf("got %d of %d prefixes without a budget", len(allowed), uncut)
+	}
+	set := NewRangeSet(allowed)
+	for _, s := range []string{"1.0.1.1", "10.1.1.1", "192.168.1.1", "93.184.216.34", "2001:250::1", "fe80::1"} {
+		if set.Contains(netip.MustParseAddr(s)) {
+			t.Errorf("%s is tunneled", s)
+		}
+	}
+	for _, s := range []string{"1.0.0.1", "8.8.8.8", "93.184.216.35", "2400:cb00::1"} {
+		if !set.Contains(netip.MustParseAddr(s)) {
+			t.Errorf("%s is not tunneled", s)
+		}
+	}
+
+	opt.Family = "ipv4"
+	opt.Platform = []interface{}{endpoint, TunnelOptions{MaxRoutes: 10, Widen: true}}
+	allowed, _, loss, err := tunnel.Tunneled(routes, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set = NewRangeSet(allowed)
+	if len(allowed) > 10 || loss.IPv4 == 0 || set.Contains(netip.MustParseAddr("2400:cb00::1")) {
+		t.Errorf("got %v, %v", allowed, loss)
+	}
+	for _, p := range Reserved {
+		if set.Overlaps(RangeOf(p)) {
+			t.Errorf("reserved %s is tunneled", p)
+		}
+	}
+
+	out := make(MemOutput)
+	if err := g.Generate(routes, Options{Family: "ipv6", Platform: []interface{}{WireguardOptions{Endpoint: "2001:db8::1"}}}, out); err != nil {
+		t.Fatal(err)
+	}
+	conf := out["wg-peer.conf"].String()
+	if !strings.HasPrefix(out["allowed-ips.txt"].String(), "AllowedIPs = 2000::/16, ") || !strings.Contains(conf, "Endpoint = [2001:db8::1]:51820\n") || !strings.Contains(conf, out["allowed-ips.txt"].String()) {
+		t.Errorf("got %q and %q", out["allowed-ips.txt"], conf)
+	}
+}
+
+type nullGenerator struct{}
+
+func (nullGenerator) Name() string                                                  { return "null" }
+func (nullGenerator) Generate(routes []netip.Prefix, opt Options, out Output) error { return nil }
+func (nullGenerator) Usage(routes []netip.Prefix, opt Options) string               { return "" }
+
+func TestRegister(t *testing.T) {
+	Register(nullGenerator{})
+	t.Cleanup(func() { delete(generators, "null") })
+	if _, ok := Lookup("null"); !ok {
+		t.Error("registered generator not found")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice should panic")
+		}
+	}()
+	Register(nullGenerator{})
+}
+
+func TestPlatformOptions(t *testing.T) {
+	opt := Options{Platform: []interface{}{ClashOptions{Rules: true}, PACOptions{Proxy: "SOCKS5 127.0.0.1:1080"}}}
+	if got := PlatformOptions[PACOptions](opt); got.Proxy != "SOCKS5 127.0.0.1:1080" {
+		t.Errorf("got %+v", got)
+	}
+	if got := PlatformOptions[IPRoute2Options](opt); got != (IPRoute2Options{}) {
+		t.Errorf("got %+v for a type not given", got)
+	}
 }
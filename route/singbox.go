@@ -0,0 +1,111 @@
+package route
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/netip"
+)
+
+func init() {
+	Register(singbox{})
+}
+
+// singboxVersion is the rule-set version written. Version 1 is read by every
+// sing-box since 1.8 and ip_cidr items have not changed since.
+const singboxVersion = 1
+
+// The parts of the binary rule-set format used here.
+const (
+	srsRuleDefault = 0
+	srsItemIPCIDR  = 6 // 5 is source_ip_cidr, 7 source_port
+	srsItemFinal   = 0xff
+	srsIPSetV1     = 1
+)
+
+// singbox writes a sing-box rule-set with one ip_cidr rule, as source JSON
+// and in the compiled .srs form that `sing-box rule-set compile` produces.
+type singbox struct{}
+
+func (singbox) Name() string { return "sing-box" }
+
+func (singbox) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	type rule struct {
+		IPCIDR []string `json:"ip_cidr"`
+	}
+	source := struct {
+		Version int    `json:"version"`
+		Rules   []rule `json:"rules"`
+	}{Version: singboxVersion, Rules: []rule{}}
+	if len(routes) > 0 {
+		var r rule
+		for _, p := range routes {
+			r.IPCIDR = append(r.IPCIDR, p.String())
+		}
+		source.Rules = append(source.Rules, r)
+	}
+	b, err := json.MarshalIndent(source, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := out.Create("rule-set.json")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	w, err = out.Create("rule-set.srs")
+	if err != nil {
+		return err
+	}
+	return writeSRS(w, routes)
+}
+
+func (singbox) Usage(routes []netip.Prefix, opt Options) string {
+	return "Add rule-set.srs to route.rule_set of your sing-box config as a local rule-set with format binary, or rule-set.json with format source, and send it to the direct outbound with a rule_set route rule."
+}
+
+// writeSRS writes the routes as a compiled rule-set: the magic and the
+// version, then a zlib stream holding the number of rules and a default rule
+// whose only item is the routes as an IP set of sorted, disjoint ranges.
+func writeSRS(w io.Writer, routes []netip.Prefix) error {
+	if _, err := w.Write([]byte{'S', 'R', 'S', singboxVersion}); err != nil {
+		return err
+	}
+	zw, err := zlib.NewWriterLevel(w, zlib.BestCompression)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(zw)
+	var buf [binary.MaxVarintLen64]byte
+	uvarint := func(x uint64) {
+		bw.Write(buf[:binary.PutUvarint(buf[:], x)])
+	}
+	if len(routes) == 0 {
+		uvarint(0)
+	} else {
+		uvarint(1)
+		bw.WriteByte(srsRuleDefault)
+		bw.WriteByte(srsItemIPCIDR)
+		bw.WriteByte(srsIPSetV1)
+		set := NewRangeSet(routes)
+		binary.Write(bw, binary.BigEndian, uint64(len(set)))
+		for _, r := range set {
+			for _, addr := range []netip.Addr{r.From, r.To} {
+				b := addr.AsSlice()
+				uvarint(uint64(len(b)))
+				bw.Write(b)
+			}
+		}
+		bw.WriteByte(srsItemFinal)
+		bw.WriteByte(0) // not inverted
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
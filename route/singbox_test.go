@@ -0,0 +1,151 @@
+package route
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// readSRS decodes a compiled rule-set holding ip_cidr items only and returns
+// the addresses of its rules as prefixes. The numbers are those of
+// common/srs in sing-box, not the constants of the writer: rule type 0 is a
+// default rule, item 6 is ip_cidr, 0xff ends a rule and is followed by the
+// invert flag, and IP sets are of version 1.
+func readSRS(r io.Reader) (version byte, prefixes []netip.Prefix, err error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	if string(head[:3]) != "SRS" {
+		return 0, nil, fmt.Errorf("bad magic %q", head[:3])
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	br := bufio.NewReader(zr)
+	rules, err := binary.ReadUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	var ranges []Range
+	for i := uint64(0); i < rules; i++ {
+		if t, _ := br.ReadByte(); t != 0 {
+			return 0, nil, fmt.Errorf("rule type %d", t)
+		}
+		for {
+			item, err := br.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			if item == 0xff {
+				if invert, _ := br.ReadByte(); invert != 0 {
+					return 0, nil, fmt.Errorf("inverted rule")
+				}
+				break
+			}
+			if item != 6 {
+				return 0, nil, fmt.Errorf("item type %d", item)
+			}
+			if v, _ := br.ReadByte(); v != 1 {
+				return 0, nil, fmt.Errorf("ip set version %d", v)
+			}
+			var n uint64
+			if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+				return 0, nil, err
+			}
+			for j := uint64(0); j < n; j++ {
+				var addrs [2]netip.Addr
+				for k := range addrs {
+					l, err := binary.ReadUvarint(br)
+					if err != nil {
+						return 0, nil, err
+					}
+					b := make([]byte, l)
+					if _, err := io.ReadFull(br, b); err != nil {
+						return 0, nil, err
+					}
+					var ok bool
+					if addrs[k], ok = netip.AddrFromSlice(b); !ok {
+						return 0, nil, fmt.Errorf("bad address %x", b)
+					}
+				}
+				ranges = append(ranges, Range{addrs[0], addrs[1]})
+			}
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return 0, nil, fmt.Errorf("trailing data")
+	}
+	return head[3], MergeRanges(ranges).Prefixes(), nil
+}
+
+func TestSRSBytes(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}
+	var buf bytes.Buffer
+	if err := writeSRS(&buf, routes); err != nil {
+		t.Fatal(err)
+	}
+	if head := buf.Next(4); !bytes.Equal(head, []byte{'S', 'R', 'S', 1}) {
+		t.Fatalf("header % x", head)
+	}
+	zr, err := zlib.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		1,                      // rules
+		0,                      // default rule
+		6,                      // ip_cidr
+		1,                      // ip set version
+		0, 0, 0, 0, 0, 0, 0, 2, // ranges
+		4, 1, 0, 1, 0, 4, 1, 0, 1, 0xff,
+		16, 0x20, 0x01, 0x02, 0x50, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		16, 0x20, 0x01, 0x02, 0x51, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, // end of rule
+		0,    // not inverted
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got % x\nwant % x", got, want)
+	}
+}
+
+func TestSRSRoundTrip(t *testing.T) {
+	for _, routes := range [][]netip.Prefix{
+		nil,
+		{netip.MustParsePrefix("1.0.1.0/24")},
+		{
+			netip.MustParsePrefix("1.0.1.0/24"),
+			netip.MustParsePrefix("1.0.2.0/23"),
+			netip.MustParsePrefix("1.0.8.0/21"),
+			netip.MustParsePrefix("223.255.252.0/23"),
+			netip.MustParsePrefix("2001:250::/31"),
+			netip.MustParsePrefix("2400:3200::/32"),
+		},
+	} {
+		var buf bytes.Buffer
+		if err := writeSRS(&buf, routes); err != nil {
+			t.Fatal(err)
+		}
+		version, got, err := readSRS(&buf)
+		if err != nil {
+			t.Fatalf("%v: %v", routes, err)
+		}
+		if version != singboxVersion {
+			t.Errorf("version %d, want %d", version, singboxVersion)
+		}
+		if !reflect.DeepEqual(got, Aggregate(routes)) {
+			t.Errorf("got %v, want %v", got, routes)
+		}
+	}
+}
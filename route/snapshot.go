@@ -0,0 +1,112 @@
+package route
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// ParseRoutes reads the routes of an earlier run back: the routes.txt of the
+// openvpn or routeos platform, or one prefix or address per line. Comments
+// and the openvpn directives other than route and route-ipv6 are skipped.
+func ParseRoutes(r io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexAny(text, "#;"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		p, ok, err := parseRoute(fields)
+		if err != nil {
+			return nil, &ParseError{Line: line, Text: scanner.Text(), Err: err}
+		}
+		if ok {
+			prefixes = append(prefixes, p.Masked())
+		}
+	}
+	return prefixes, scanner.Err()
+}
+
+// parseRoute returns the destination of one line split into fields, and
+// false for a line that holds none.
+func parseRoute(fields []string) (netip.Prefix, bool, error) {
+	switch {
+	case fields[0] == "route":
+		if len(fields) < 3 {
+			return netip.Prefix{}, false, errors.New("route without a netmask")
+		}
+		addr, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return netip.Prefix{}, false, err
+		}
+		mask, err := netip.ParseAddr(fields[2])
+		if err != nil || !mask.Is4() {
+			return netip.Prefix{}, false, errors.New("bad netmask " + fields[2])
+		}
+		bits := maskBits(mask)
+		if bits < 0 {
+			return netip.Prefix{}, false, errors.New("bad netmask " + fields[2])
+		}
+		return netip.PrefixFrom(addr, bits), true, nil
+	case fields[0] == "route-ipv6":
+		if len(fields) < 2 {
+			return netip.Prefix{}, false, errors.New("route-ipv6 without a prefix")
+		}
+		p, err := netip.ParsePrefix(fields[1])
+		return p, err == nil, err
+	case strings.HasPrefix(fields[0], "/ip"):
+		for _, f := range fields {
+			if strings.HasPrefix(f, "address=") {
+				p, err := parsePrefixOrAddr(strings.TrimPrefix(f, "address="))
+				return p, err == nil, err
+			}
+		}
+		return netip.Prefix{}, false, nil
+	case len(fields) == 1:
+		p, err := parsePrefixOrAddr(fields[0])
+		if err != nil && !strings.ContainsAny(fields[0], ".:") {
+			// A bare openvpn directive such as "client".
+			return netip.Prefix{}, false, nil
+		}
+		return p, err == nil, err
+	}
+	// Any other openvpn directive, such as "max-routes 8000".
+	return netip.Prefix{}, false, nil
+}
+
+func parsePrefixOrAddr(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, aerr := netip.ParseAddr(s)
+		if aerr != nil {
+			return netip.Prefix{}, err
+		}
+		p = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return p, nil
+}
+
+// maskBits returns the prefix length of a dotted netmask, or -1 if its ones
+// are not contiguous.
+func maskBits(mask netip.Addr) int {
+	b := mask.As4()
+	m := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	bits := 0
+	for m&(1<<31) != 0 {
+		m <<= 1
+		bits++
+	}
+	if m != 0 {
+		return -1
+	}
+	return bits
+}
@@ -0,0 +1,85 @@
+package route
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	in := "max-routes 8000\nclient\nroute 1.0.1.0 255.255.255.0 net_gateway 5\nroute-ipv6 2001:250::/31 net_gateway_ipv6 5\n" +
+		"; old entry\n/ip firewall address-list add list=chnroutes address=1.0.8.0/21\n5.6.7.8\n"
+	got, err := ParseRoutes(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.0.1.0/24", "2001:250::/31", "1.0.8.0/21", "5.6.7.8/32"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v", got)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("prefix %d: got %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	for _, bad := range []string{"route 1.0.1.0 255.0.255.0", "route 1.0.1.0", "1.0.1"} {
+		_, err := ParseRoutes(strings.NewReader("route 1.0.1.0 255.255.255.0\n" + bad + "\n"))
+		if perr, ok := err.(*ParseError); !ok || perr.Line != 2 {
+			t.Errorf("%q: got %v, want a parse error on line 2", bad, err)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	add := []netip.Prefix{netip.MustParsePrefix("1.0.2.0/23")}
+	del := []netip.Prefix{netip.MustParsePrefix("1.0.1.0/24"), netip.MustParsePrefix("2001:250::/31")}
+	want := map[string]map[string]string{
+		"openvpn": {"routes-update.txt": "# removed: route 1.0.1.0 255.255.255.0 net_gateway 5\n# removed: route-ipv6 2001:250::/31 net_gateway_ipv6 5\nroute 1.0.2.0 255.255.254.0 net_gateway 5\n"},
+		"routeos": {"routes-update.txt": "/ip firewall address-list remove [/ip firewall address-list find list=chnroutes address=1.0.1.0/24]\n" +
+			"/ipv6 firewall address-list remove [/ipv6 firewall address-list find list=chnroutes address=2001:250::/31]\n/ip firewall address-list add list=chnroutes address=1.0.2.0/23\n"},
+		"nftables": {"chnroutes-update.nft": "#!/usr/sbin/nft -f\n\ndelete element inet chnroutes chnroutes_v4 { 1.0.1.0/24 }\ndelete element inet chnroutes chnroutes_v6 { 2001:250::/31 }\nadd element inet chnroutes chnroutes_v4 { 1.0.2.0/23 }\n"},
+		"ipset":    {"chnroutes-update.ipset": "del chnroutes 1.0.1.0/24\ndel chnroutes6 2001:250::/31\nadd chnroutes 1.0.2.0/23\n"},
+		"win": {"vpnupdate.bat": `for /F "tokens=3" %%* in ('route print ^| findstr "\\<0.0.0.0\\>"') do set "gw=%%*"` + "\n" +
+			`for /F "tokens=1,4" %%a in ('route print -6 ^| findstr /C:" ::/0 "') do (set "if6=%%a" & set "gw6=%%b")` + "\n" +
+			"route delete 1.0.1.0 mask 255.255.255.0\nnetsh interface ipv6 delete route 2001:250::/31 %if6%\nroute add 1.0.2.0 mask 255.255.254.0 %gw% metric 5\n"},
+		"iproute2": {"routes-update.batch": "route del 1.0.1.0/24 table main metric 5\nroute del 2001:250::/31 table main metric 5\nroute replace 1.0.2.0/23 $OLDGW table main metric 5\n"},
+	}
+	for _, name := range Platforms() {
+		g, _ := Lookup(name)
+		u, ok := g.(Updater)
+		if !ok {
+			continue
+		}
+		out := make(MemOutput)
+		if err := u.Update(add, del, Options{Metric: 5, List: "chnroutes"}, out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out) == 0 {
+			t.Errorf("%s wrote nothing", name)
+		}
+		for file, content := range want[name] {
+			if got := out[file].String(); got != content {
+				t.Errorf("%s %s: got %q, want %q", name, file, got, content)
+			}
+		}
+	}
+
+	// The vpn holds the default route when the update runs, so iproute2
+	// takes the gateways the up script saved, or those given.
+	g, _ := Lookup("iproute2")
+	for _, ro := range []IPRoute2Options{{}, {Gateway: "192.168.1.1", Gateway6: "eth0"}} {
+		out := make(MemOutput)
+		if err := g.(Updater).Update(add, del, Options{Platform: []interface{}{ro}}, out); err != nil {
+			t.Fatal(err)
+		}
+		script := out["iproute2-update.sh"].String()
+		if saved := strings.Contains(script, ". /tmp/vpn_oldgw_iproute2\n"); saved != (ro.Gateway == "") || strings.Contains(script, "ip route show default") {
+			t.Errorf("%+v: got %q", ro, script)
+		}
+	}
+
+	if err := WriteUpdate(t.TempDir(), nullGenerator{}, add, del, Options{}); err == nil {
+		t.Error("a generator without Update should be refused")
+	}
+}
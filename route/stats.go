@@ -0,0 +1,309 @@
+package route
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The statuses of a delegation. Extended files also list the space a
+// registry holds as available or reserved.
+const (
+	StatusAllocated = "allocated"
+	StatusAssigned  = "assigned"
+	StatusAvailable = "available"
+	StatusReserved  = "reserved"
+)
+
+// Entry is one line of a statistics file: a *Header, a *Summary or a
+// *Delegation.
+type Entry interface {
+	// String formats the entry as a line of the file.
+	String() string
+}
+
+// Header is the version line that opens a delegated statistics file.
+type Header struct {
+	Version   string
+	Registry  string
+	Serial    string
+	Records   int       // records in the file, not counting the header, summaries and comments
+	StartDate time.Time // zero when the file does not carry a date
+	EndDate   time.Time
+	UTCOffset string
+	Text      string // the line as read, kept for compatibility
+}
+
+// Summary is a registry|*|type|*|count|summary line.
+type Summary struct {
+	Registry string
+	Type     string // asn, ipv4 or ipv6
+	Count    int
+	Text     string // the line as read, kept for compatibility
+}
+
+// Delegation is an asn, ipv4 or ipv6 record. Start is an address, or an
+// AS number for asn records, and Value is the number of addresses, the
+// prefix length of ipv6 records or the number of AS numbers.
+type Delegation struct {
+	Registry   string
+	Country    string // upper case, empty in some extended files
+	Type       string
+	ASN        uint32     // asn records only
+	Start      netip.Addr // ipv4 and ipv6 records only
+	Value      uint64
+	Date       time.Time // zero when the file does not carry a date
+	Status     string
+	OpaqueID   string   // extended files only
+	Extensions []string // fields after the opaque-id
+	Text       string   // the line as read, kept for compatibility
+}
+
+// ParseEntry parses one line of a statistics file. Comments and blank
+// lines give a nil entry and no error.
+func ParseEntry(line string) (Entry, error) {
+	if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	fields := strings.Split(line, "|")
+	switch {
+	case isVersion(fields):
+		h, err := ParseHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		return &h, nil
+	case len(fields) >= 6 && fields[5] == "summary":
+		return parseSummary(line, fields)
+	}
+	return parseDelegation(line, fields)
+}
+
+// ParseHeader parses a version|registry|serial|records|startdate|enddate|
+// UTCoffset line.
+func ParseHeader(line string) (Header, error) {
+	fields := strings.Split(line, "|")
+	if !isVersion(fields) {
+		return Header{}, fmt.Errorf("%q is not a version line", line)
+	}
+	if len(fields) != 7 {
+		return Header{}, fmt.Errorf("got %d fields, want 7", len(fields))
+	}
+	records, err := strconv.Atoi(fields[3])
+	if err != nil || records < 0 {
+		return Header{}, fmt.Errorf("bad record count %q", fields[3])
+	}
+	h := Header{Version: fields[0], Registry: strings.ToLower(fields[1]), Serial: fields[2], Records: records, UTCOffset: fields[6], Text: line}
+	if h.StartDate, err = parseDate(fields[4]); err != nil {
+		return Header{}, err
+	}
+	if h.EndDate, err = parseDate(fields[5]); err != nil {
+		return Header{}, err
+	}
+	return h, nil
+}
+
+// isVersion reports whether a split line is a version line, whose first
+// field is a format version such as 2 or 2.3.
+func isVersion(fields []string) bool {
+	if fields[0] == "" || strings.Trim(fields[0], "0123456789.") != "" {
+		return false
+	}
+	_, err := strconv.ParseFloat(fields[0], 64)
+	return err == nil
+}
+
+func parseSummary(line string, fields []string) (*Summary, error) {
+	if len(fields) != 6 || fields[1] != "*" || fields[3] != "*" {
+		return nil, errors.New("malformed summary line")
+	}
+	if err := checkType(fields[2]); err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(fields[4])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("bad summary count %q", fields[4])
+	}
+	return &Summary{Registry: strings.ToLower(fields[0]), Type: fields[2], Count: count, Text: line}, nil
+}
+
+func parseDelegation(line string, fields []string) (*Delegation, error) {
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("got %d fields, want at least 7", len(fields))
+	}
+	d := &Delegation{Registry: strings.ToLower(fields[0]), Country: strings.ToUpper(fields[1]), Type: fields[2], Status: strings.ToLower(fields[6]), Text: line}
+	if !isWord(d.Registry) {
+		return nil, fmt.Errorf("bad registry %q", fields[0])
+	}
+	if d.Country != "" && (len(d.Country) != 2 || !isWord(strings.ToLower(d.Country))) {
+		return nil, fmt.Errorf("bad country code %q", fields[1])
+	}
+	if err := checkType(d.Type); err != nil {
+		return nil, err
+	}
+	if !isWord(d.Status) {
+		return nil, fmt.Errorf("bad status %q", fields[6])
+	}
+	if len(fields) > 7 {
+		d.OpaqueID = fields[7]
+		d.Extensions = fields[8:]
+	}
+	var err error
+	if d.Date, err = parseDate(fields[5]); err != nil {
+		return nil, err
+	}
+	if d.Value, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+		return nil, fmt.Errorf("bad value %q", fields[4])
+	}
+
+	switch d.Type {
+	case "asn":
+		asn, err := strconv.ParseUint(fields[3], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bad AS number %q", fields[3])
+		}
+		d.ASN = uint32(asn)
+		if d.Value == 0 || uint64(d.ASN)+d.Value > 1<<32 {
+			return nil, fmt.Errorf("%d AS numbers from %d do not fit the AS number space", d.Value, d.ASN)
+		}
+	case "ipv4":
+		if d.Start, err = netip.ParseAddr(fields[3]); err != nil || !d.Start.Is4() {
+			return nil, fmt.Errorf("%s is not a valid ipv4 block", fields[3])
+		}
+		if _, err := rangePrefixes(d.Start, d.Value); err != nil {
+			return nil, err
+		}
+	case "ipv6":
+		if d.Start, err = netip.ParseAddr(fields[3]); err != nil || !d.Start.Is6() || d.Start.Is4In6() || d.Start.Zone() != "" || d.Value > 128 {
+			return nil, fmt.Errorf("%s is not a valid ipv6 block", fields[3])
+		}
+	}
+	return d, nil
+}
+
+// Records turns an ipv4 or ipv6 delegation into records, one per CIDR
+// block; asn delegations give none.
+func (d *Delegation) Records() []Record {
+	var prefixes []netip.Prefix
+	switch d.Type {
+	case "ipv4":
+		prefixes, _ = rangePrefixes(d.Start, d.Value)
+	case "ipv6":
+		prefixes = []netip.Prefix{netip.PrefixFrom(d.Start, int(d.Value)).Masked()}
+	}
+	var records []Record
+	for _, p := range prefixes {
+		records = append(records, Record{
+			Registry: d.Registry,
+			Country:  d.Country,
+			Prefix:   p,
+			Status:   d.Status,
+			Date:     d.Date,
+			OpaqueID: d.OpaqueID,
+		})
+	}
+	return records
+}
+
+func (h *Header) String() string {
+	return strings.Join([]string{h.Version, h.Registry, h.Serial, strconv.Itoa(h.Records), formatDate(h.StartDate), formatDate(h.EndDate), h.UTCOffset}, "|")
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("%s|*|%s|*|%d|summary", s.Registry, s.Type, s.Count)
+}
+
+func (d *Delegation) String() string {
+	start := d.Start.String()
+	if d.Type == "asn" {
+		start = strconv.FormatUint(uint64(d.ASN), 10)
+	}
+	fields := []string{d.Registry, d.Country, d.Type, start, strconv.FormatUint(d.Value, 10), formatDate(d.Date), d.Status}
+	if d.OpaqueID != "" || len(d.Extensions) > 0 {
+		fields = append(append(fields, d.OpaqueID), d.Extensions...)
+	}
+	return strings.Join(fields, "|")
+}
+
+// StatsReader reads the entries of a statistics file one line at a time.
+type StatsReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewStatsReader returns a reader of the entries of r.
+func NewStatsReader(r io.Reader) *StatsReader {
+	return &StatsReader{scanner: bufio.NewScanner(r)}
+}
+
+// Next returns the next entry, skipping comments and blank lines, and
+// io.EOF after the last one. A malformed or overlong line gives a
+// *ParseError.
+func (r *StatsReader) Next() (Entry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		e, err := ParseEntry(r.scanner.Text())
+		if err != nil {
+			return nil, &ParseError{Line: r.line, Text: r.scanner.Text(), Err: err}
+		}
+		if e != nil {
+			return e, nil
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, &ParseError{Line: r.line + 1, Err: err}
+		}
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Line returns the number of the line the last entry came from.
+func (r *StatsReader) Line() int { return r.line }
+
+// Text returns the line the last entry came from.
+func (r *StatsReader) Text() string { return r.scanner.Text() }
+
+func checkType(t string) error {
+	if t != "asn" && t != "ipv4" && t != "ipv6" {
+		return fmt.Errorf("unknown record type %q", t)
+	}
+	return nil
+}
+
+// isWord reports whether s is a non-empty run of lower case letters.
+func isWord(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" || s == "00000000" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("20060102", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad date %q", s)
+	}
+	return t, nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("20060102")
+}
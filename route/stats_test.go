@@ -0,0 +1,134 @@
+package route
+
+import (
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEntry(t *testing.T) {
+	date := time.Date(2011, 4, 14, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		line string
+		want Entry
+	}{
+		{"# comment", nil},
+		{"", nil},
+		{"2.3|ripencc|1700000000|3|19830705|20240101|+0100", &Header{Version: "2.3", Registry: "ripencc", Serial: "1700000000", Records: 3, StartDate: time.Date(1983, 7, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), UTCOffset: "+0100"}},
+		{"apnic|*|ipv4|*|33077|summary", &Summary{Registry: "apnic", Type: "ipv4", Count: 33077}},
+		{"apnic|JP|asn|173|1|20020801|allocated", &Delegation{Registry: "apnic", Country: "JP", Type: "asn", ASN: 173, Value: 1, Date: time.Date(2002, 8, 1, 0, 0, 0, 0, time.UTC), Status: StatusAllocated}},
+		{"apnic|cn|ipv4|1.0.1.0|768|20110414|allocated|A91A7381", &Delegation{Registry: "apnic", Country: "CN", Type: "ipv4", Start: netip.MustParseAddr("1.0.1.0"), Value: 768, Date: date, Status: StatusAllocated, OpaqueID: "A91A7381", Extensions: []string{}}},
+		{"arin||ipv4|23.128.0.0|1024||available||e-stats", &Delegation{Registry: "arin", Type: "ipv4", Start: netip.MustParseAddr("23.128.0.0"), Value: 1024, Status: StatusAvailable, Extensions: []string{"e-stats"}}},
+		{"ripencc|ZZ|ipv6|2001:678::|29|00000000|reserved", &Delegation{Registry: "ripencc", Country: "ZZ", Type: "ipv6", Start: netip.MustParseAddr("2001:678::"), Value: 29, Status: StatusReserved}},
+	}
+	for _, c := range cases {
+		got, err := ParseEntry(c.line)
+		if err != nil {
+			t.Errorf("%q: %v", c.line, err)
+			continue
+		}
+		if c.want == nil {
+			if got != nil {
+				t.Errorf("%q: got %v, want nothing", c.line, got)
+			}
+			continue
+		}
+		if got == nil || got.String() != c.want.String() {
+			t.Errorf("%q: got %v, want %v", c.line, got, c.want)
+		}
+		var text string
+		switch e := got.(type) {
+		case *Header:
+			text = e.Text
+		case *Summary:
+			text = e.Text
+		case *Delegation:
+			text = e.Text
+		}
+		if text != c.line {
+			t.Errorf("%q: got text %q", c.line, text)
+		}
+		if d, ok := got.(*Delegation); ok {
+			w := c.want.(*Delegation)
+			if d.Country != w.Country || d.Status != w.Status || d.OpaqueID != w.OpaqueID || len(d.Extensions) != len(w.Extensions) || d.ASN != w.ASN {
+				t.Errorf("%q: got %+v, want %+v", c.line, d, w)
+			}
+		}
+	}
+
+	for _, line := range []string{
+		"apnic|CN|ipv4|1.0|1.0|256|20110414|allocated",
+		"apnic|CN|ipv4|1.0.1.0|0|20110414|allocated",
+		"apnic|CN|ipv4|255.255.255.0|512|20110414|allocated",
+		"apnic|CN|ipv4|2001:250::|256|20110414|allocated",
+		"apnic|CN|ipv6|2001:250::|129|20110414|allocated",
+		"apnic|CN|ipv6|fe80::1%eth0|64|20110414|allocated",
+		"apnic|CN|asn|4294967295|2|20110414|allocated",
+		"apnic|CHN|ipv4|1.0.1.0|256|20110414|allocated",
+		"apnic|CN|ipv4|1.0.1.0|256|20111314|allocated",
+		"apnic|CN|ipv4|1.0.1.0|256|20110414|",
+		"apnic|CN|ipx|1.0.1.0|256|20110414|allocated",
+		"apnic|*|ipv4|*|-1|summary",
+		"2|apnic|20160323|44500|19850701|20160322",
+	} {
+		if e, err := ParseEntry(line); err == nil {
+			t.Errorf("%q: got %v, want an error", line, e)
+		}
+	}
+}
+
+func TestStatsReader(t *testing.T) {
+	in := "# header\n2|apnic|20160323|2|19850701|20160322|+1000\n\napnic|*|ipv4|*|1|summary\napnic|CN|ipv4|1.0.1.0|256|20110414|allocated\napnic|CN|ipv4|1.0.1|256|20110414|allocated\n"
+	r := NewStatsReader(strings.NewReader(in))
+	for _, line := range []int{2, 4, 5} {
+		if _, err := r.Next(); err != nil || r.Line() != line {
+			t.Fatalf("got line %d and %v, want line %d", r.Line(), err, line)
+		}
+	}
+	_, err := r.Next()
+	if perr, ok := err.(*ParseError); !ok || perr.Line != 6 {
+		t.Errorf("got %v, want a parse error on line 6", err)
+	}
+
+	r = NewStatsReader(strings.NewReader("apnic|*|ipv6|*|0|summary"))
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got %v, want io.EOF", err)
+	}
+}
+
+// FuzzParseEntry checks that any line either fails or parses into an entry
+// that formats back to a line giving the same entry.
+func FuzzParseEntry(f *testing.F) {
+	for _, seed := range []string{
+		"2|apnic|20160323|44500|19850701|20160322|+1000",
+		"apnic|*|asn|*|6799|summary",
+		"apnic|JP|asn|173|1|20020801|allocated",
+		"apnic|CN|ipv4|1.0.1.0|768|20110414|allocated|A91A7381",
+		"arin||ipv4|23.128.0.0|1024||available||e-stats",
+		"apnic|CN|ipv6|2001:250::|31|20000426|allocated",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		e, err := ParseEntry(line)
+		if err != nil || e == nil {
+			return
+		}
+		s := e.String()
+		again, err := ParseEntry(s)
+		if err != nil {
+			t.Fatalf("%q formats as %q, which fails: %v", line, s, err)
+		}
+		if again.String() != s {
+			t.Fatalf("%q formats as %q, then as %q", line, s, again.String())
+		}
+		if d, ok := e.(*Delegation); ok && d.Type != "asn" && len(d.Records()) == 0 {
+			t.Fatalf("%q gives no records", line)
+		}
+	})
+}
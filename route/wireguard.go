@@ -0,0 +1,112 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+func init() {
+	Register(wireguard{})
+}
+
+// wireguard writes the AllowedIPs of a peer. WireGuard has no way to send
+// some destinations around the tunnel, so the list holds every address that
+// is neither in the routes nor reserved, and the routes go direct because
+// wg-quick adds no route for them.
+type wireguard struct{}
+
+// WireguardOptions are the settings of the wireguard generator.
+type WireguardOptions struct {
+	Endpoint string // vpn server address, left out of the tunnel
+}
+
+func (wireguard) Name() string { return "wireguard" }
+
+// Tunneled returns the addresses outside the routes and the reserved space,
+// cut down to TunnelOptions.MaxRoutes, with the length of the list before the cut and
+// the addresses the cut moved to the other side.
+func (wireguard) Tunneled(routes []netip.Prefix, opt Options) (tunneled []netip.Prefix, uncut int, loss Loss, err error) {
+	endpoint, to := PlatformOptions[WireguardOptions](opt).Endpoint, PlatformOptions[TunnelOptions](opt)
+	reserved := opt.Reserved
+	if reserved == nil {
+		reserved = Reserved
+	}
+	if endpoint != "" {
+		addr, err := netip.ParseAddr(endpoint)
+		if err != nil {
+			ap, aerr := netip.ParseAddrPort(endpoint)
+			if aerr != nil {
+				return nil, 0, Loss{}, fmt.Errorf("Endpoint %s is not an address.", endpoint)
+			}
+			addr = ap.Addr()
+		}
+		// The tunnel packets themselves must not enter the tunnel.
+		addr = addr.Unmap()
+		reserved = append(append([]netip.Prefix{}, reserved...), netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	var allowed []netip.Prefix
+	for _, p := range Invert(routes, reserved) {
+		if (opt.Family == "ipv4" && !p.Addr().Is4()) || (opt.Family == "ipv6" && !p.Addr().Is6()) {
+			continue
+		}
+		allowed = append(allowed, p)
+	}
+	if to.MaxRoutes <= 0 || len(allowed) <= to.MaxRoutes {
+		return allowed, len(allowed), Loss{}, nil
+	}
+
+	// Merged entries must not swallow reserved space such as the local
+	// network, which has to stay out of the tunnel.
+	tunneled, loss, err = LimitAvoiding(allowed, to.MaxRoutes, to.Widen, reserved)
+	return tunneled, len(allowed), loss, err
+}
+
+func (w wireguard) Generate(routes []netip.Prefix, opt Options, out Output) error {
+	allowed, _, _, err := w.Tunneled(routes, opt)
+	if err != nil {
+		return err
+	}
+	list := make([]string, len(allowed))
+	for i, p := range allowed {
+		list[i] = p.String()
+	}
+	line := "AllowedIPs = " + strings.Join(list, ", ") + "\n"
+
+	f, err := out.Create("allowed-ips.txt")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	bw.WriteString(line)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	f, err = out.Create("wg-peer.conf")
+	if err != nil {
+		return err
+	}
+	endpoint := PlatformOptions[WireguardOptions](opt).Endpoint
+	bw = bufio.NewWriter(f)
+	bw.WriteString("[Peer]\nPublicKey = <server public key>\n")
+	if addr, err := netip.ParseAddr(endpoint); err == nil {
+		fmt.Fprintf(bw, "Endpoint = %s\n", netip.AddrPortFrom(addr, 51820))
+	} else if endpoint != "" {
+		fmt.Fprintf(bw, "Endpoint = %s\n", endpoint)
+	} else {
+		bw.WriteString("Endpoint = <server address>:51820\n")
+	}
+	bw.WriteString(line)
+	bw.WriteString("PersistentKeepalive = 25\n")
+	return bw.Flush()
+}
+
+func (wireguard) Usage(routes []netip.Prefix, opt Options) string {
+	usage := "Replace the AllowedIPs of the server peer with the line in allowed-ips.txt, or paste wg-peer.conf as the peer section of your wg-quick config after filling in the key and the endpoint."
+	if PlatformOptions[WireguardOptions](opt).Endpoint == "" {
+		usage += " Set -endpoint to the address of the server, or its packets are sent into the tunnel when it lies outside the selection."
+	}
+	return usage
+}